@@ -0,0 +1,149 @@
+package bot
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditRecord describes a finished task.
+type AuditRecord struct {
+	Time   Time
+	Bot    string
+	From   string `json:",omitempty"`
+	Target string `json:",omitempty"`
+	Rule   string `json:",omitempty"`
+	Script string `json:",omitempty"`
+	// Command is the expanded command line.
+	Command    string
+	Tid        TaskId
+	WallMs     int64
+	Lines      uint32
+	ExitCode   int
+	KillReason string `json:",omitempty"`
+	Err        string `json:",omitempty"`
+}
+
+// AuditQuery filters audit records; zero fields match everything.
+type AuditQuery struct {
+	// User matches the sender's nick or a mask on the sender's prefix.
+	User   string
+	Script string
+	Since  time.Time
+	Until  time.Time
+	// Limit keeps only the most recent matching records.
+	Limit int
+}
+
+func (q *AuditQuery) match(r *AuditRecord) bool {
+	if q.User != "" {
+		nick, _, _ := strings.Cut(r.From, "!")
+		ok := MatchMask(q.User, r.From)
+		if !ok && !strings.EqualFold(nick, q.User) {
+			return false
+		}
+	}
+	if q.Script != "" && q.Script != r.Script {
+		return false
+	}
+	t := r.Time.T()
+	if !q.Since.IsZero() && t.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !t.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Audit appends a bot's task records to a JSON lines file.
+type Audit struct {
+	id string
+	f  *os.File
+	mu sync.Mutex
+}
+
+func OpenAudit(dir, id string) (*Audit, error) {
+	if !fileId(id) {
+		return nil, fmt.Errorf("bad audit id %q", id)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, id+".jsonl"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Audit{id: id, f: f}, nil
+}
+
+// fileId reports whether a bot id is safe to name files after.
+func fileId(id string) bool {
+	return id != "" && !strings.ContainsAny(id, "/\\") && !strings.HasPrefix(id, ".")
+}
+
+// audited reports whether a finished task belongs in the audit log. Tasks
+// the bot runs for itself, with no script and no user, are left out.
+func (t *Task) audited() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.script != "" || t.From != ""
+}
+
+func (a *Audit) record(t *Task) error {
+	t.mu.Lock()
+	r := AuditRecord{
+		Time:       t.Start,
+		Bot:        a.id,
+		From:       t.From,
+		Target:     t.Target,
+		Rule:       t.Rule,
+		Script:     t.script,
+		Command:    t.Command,
+		Tid:        t.tid,
+		WallMs:     t.Wall().Milliseconds(),
+		Lines:      t.Lines(),
+		ExitCode:   t.ExitCode,
+		KillReason: t.KillReason,
+		Err:        t.Err,
+	}
+	t.mu.Unlock()
+	b, err := json.Marshal(&r)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.f.Write(append(b, '\n'))
+	return err
+}
+
+// Query returns the matching records, oldest first. It reads the log
+// while records are appended, skipping any partly written last line.
+func (a *Audit) Query(q AuditQuery) (ret []AuditRecord, err error) {
+	f, err := os.Open(a.f.Name())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		var r AuditRecord
+		if json.Unmarshal(s.Bytes(), &r) != nil || !q.match(&r) {
+			continue
+		}
+		ret = append(ret, r)
+		if q.Limit > 0 && len(ret) > q.Limit {
+			ret = ret[1:]
+		}
+	}
+	return ret, s.Err()
+}
+
+func (a *Audit) Close() error { return a.f.Close() }
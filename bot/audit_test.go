@@ -0,0 +1,66 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/time/rate"
+)
+
+func TestAudit(t *testing.T) {
+	a, err := OpenAudit(t.TempDir(), "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ts := NewTasks(context.Background(), rate.NewLimiter(rate.Inf, 1), nil, nil)
+	defer ts.Close()
+	ts.audit = a
+	run := func(name, from, script string) {
+		task, err := ts.run(name, name, Limits{}, false, func(t *Task) error {
+			t.mu.Lock()
+			t.From, t.script = from, script
+			t.mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-task.donec
+	}
+	run("ping", "", "")
+	run("control", "alice!a@admin.example", "")
+	run("echo", "bob!b@home.example", "echo")
+	run("timer", "", "weather")
+
+	tests := []struct {
+		q    AuditQuery
+		want []string
+	}{
+		{AuditQuery{}, []string{"control", "echo", "timer"}},
+		{AuditQuery{User: "ALICE"}, []string{"control"}},
+		{AuditQuery{User: "*!*@home.example"}, []string{"echo"}},
+		{AuditQuery{Script: "weather"}, []string{"timer"}},
+		{AuditQuery{Limit: 1}, []string{"timer"}},
+	}
+	for _, tt := range tests {
+		recs, err := a.Query(tt.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, r := range recs {
+			got = append(got, r.Command)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%+v: got %q, want %q", tt.q, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%+v: got %q, want %q", tt.q, got, tt.want)
+				break
+			}
+		}
+	}
+}
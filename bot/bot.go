@@ -2,18 +2,31 @@ package bot
 
 import (
 	"context"
+	"encoding/json"
+	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/time/rate"
 	"gopkg.in/sorcix/irc.v2"
 )
 
+// quitWait bounds waiting for the server to hang up after QUIT.
+const quitWait = 2 * time.Second
+
 type Time time.Time
 
 func (t Time) Elapsed() time.Duration { return time.Since(t.T()).Round(time.Second) }
 func (t Time) T() time.Time           { return time.Time(t) }
 
+func (t Time) MarshalJSON() ([]byte, error) { return json.Marshal(t.T()) }
+
+func (t *Time) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*time.Time)(t))
+}
+
 type Stage interface {
 	Process(msg irc.Message) error
 }
@@ -25,33 +38,79 @@ type Bot struct {
 	dispatcher *Dispatcher
 	State      *State
 	Login      *Login
+	services   *Services
+	ctcp       *CTCP
+	// Guard enforces the profile's channel guards.
+	Guard *Guard `json:"-"`
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	Tasks *Tasks
+	Tasks     *Tasks
+	Scheduler *Scheduler
+	Timers    *Timers
+	// Transfers handles the bot's DCC file transfers.
+	Transfers *DCC `json:"-"`
+	// Audit records the bot's finished tasks, if set.
+	Audit *Audit `json:"-"`
+	// Recorder records the bot's traffic, if set.
+	Recorder *Recorder `json:"-"`
 
 	mc *TeeMsgConn
 	wg sync.WaitGroup
 
+	// controlc queues owner commands sent over IRC.
+	controlc chan irc.Message
+	// gang holds the bot once it is online, if launched by a Gang.
+	gang *Gang
+
+	// clients counts connected bouncer clients.
+	clients int64
+
 	mu sync.RWMutex
 }
 
 func (b *Bot) Ctx() context.Context { return b.ctx }
 
 func (b *Bot) Update(p Profile) error {
+	scheds, err := compileSchedules(p.Schedules)
+	if err != nil {
+		return err
+	}
 	if err := b.dispatcher.Update(p.Patterns, p.PatternsRaw); err != nil {
 		return err
 	}
 	b.mu.Lock()
 	b.Profile = p
 	b.mu.Unlock()
+	if b.Scheduler != nil {
+		b.Scheduler.set(scheds)
+	}
+	if b.Transfers != nil {
+		b.Transfers.setConfig(p.DCC)
+	}
+	if b.ctcp != nil {
+		b.ctcp.update(p.CTCP)
+	}
+	if b.Guard != nil {
+		if err := b.Guard.update(p.Guards); err != nil {
+			log.Printf("[guard] %v", err)
+		}
+	}
 	return nil
 }
 
-func NewBot(ctx context.Context, p Profile) (_ *Bot, err error) {
+func NewBot(ctx context.Context, p Profile) (*Bot, error) {
+	return newBot(ctx, p, func(string) {}, nil, "")
+}
+
+// newBot is NewBot, calling progress as it dials and registers, recording
+// tasks to audit, which the bot closes, and recording traffic to a new
+// file in recordDir if set.
+func newBot(ctx context.Context, p Profile, progress func(string), audit *Audit, recordDir string) (_ *Bot, err error) {
 	cctx, cancel := context.WithCancel(ctx)
 	b := &Bot{Profile: p,
+		Audit:  audit,
 		Start:  Time(time.Now()),
 		State:  NewState(),
 		ctx:    cctx,
@@ -63,31 +122,68 @@ func NewBot(ctx context.Context, p Profile) (_ *Bot, err error) {
 			b.Close()
 		}
 	}()
-	conn, cerr := p.Dial(cctx)
-	if cerr != nil {
-		return nil, cerr
+	progress("dialing")
+	tr, terr := p.DialTransport(cctx)
+	if terr != nil {
+		return nil, terr
 	}
-	if b.mc, err = NewTeeMsgConn(cctx, conn, p.RateMs); err != nil {
+	if b.mc, err = NewTeeMsgConn(cctx, tr, p.RateMs); err != nil {
 		return nil, err
 	}
+	if recordDir != "" {
+		if b.Recorder, err = OpenRecorder(recordDir, p.Id); err != nil {
+			return nil, err
+		}
+		b.Recorder.hookTx(b.mc.MsgConn)
+		// Record first so the recording starts with the connection.
+		b.AddStage(b.Recorder)
+	}
 
+	fmtr, err := NewFormatter(p.Charset)
+	if err != nil {
+		return nil, err
+	}
+	fmtr.SetPrefix(&irc.Prefix{Name: p.Nick})
 	limiter := rate.NewLimiter(rate.Every(time.Duration(p.RateMs)*time.Millisecond), 1)
-	b.Tasks = NewTasks(cctx, limiter, b.mc.MsgConn)
+	b.Tasks = NewTasks(cctx, limiter, b.mc.MsgConn, fmtr)
+	b.Tasks.audit = audit
 
 	// Build pipeline.
 	b.dispatcher = NewDispatcher(&b.Profile, b.Tasks)
+	b.dispatcher.control = b.control
+	b.controlc = make(chan irc.Message, controlQueueLen)
+	b.wg.Add(1)
+	go b.runControl()
+	b.Timers = NewTimers(cctx, b.dispatcher)
+	b.Transfers = NewDCC(b, p.DCC, localIP(tr))
+	b.Tasks.directive = b.directive
 	if err = b.Update(b.Profile); err != nil {
 		return nil, err
 	}
-	b.Login = NewLogin(&b.Profile.ProfileLogin, b.Tasks)
+	// Login keeps its own copy so profile updates cannot race with it.
+	login := b.Profile.ProfileLogin
+	b.Login = NewLogin(&login, b.Tasks)
 	b.AddStage(b.Login)
+	b.services = NewServices(b)
+	b.AddStage(b.services)
 	if b.Verbosity > 0 {
 		b.AddStage(&Log{b.dispatcher})
 	} else {
 		b.AddStage(b.dispatcher)
 	}
-	b.AddStage(b.State)
+	// The guard acts on ops and users as the state sees them.
+	b.Guard = NewGuard(b)
+	b.AddStage(Stages{b.State, b.Guard})
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.Guard.run(cctx)
+	}()
+	b.ctcp = NewCTCP(b)
+	b.AddStage(b.ctcp)
+	b.AddStage(b.Transfers)
 	// Login.
+	progress("registering")
 	if err := b.Login.Run(); err != nil {
 		return nil, err
 	}
@@ -96,20 +192,38 @@ func NewBot(ctx context.Context, p Profile) (_ *Bot, err error) {
 	case <-b.mc.ctx.Done():
 		return nil, b.mc.ctx.Err()
 	}
+	b.services.waitIdentified(cctx)
 	// Join channels.
 	for _, ch := range p.Chans {
 		b.Tasks.Run("JOIN", "JOIN", func(t *Task) error {
 			return t.Write(irc.Message{Command: irc.JOIN, Params: []string{ch}})
 		})
 	}
+	b.Scheduler = NewScheduler(cctx, b.dispatcher)
+	if err = b.Update(b.Profile); err != nil {
+		return nil, err
+	}
 	return b, nil
 }
 
+// Stages runs stages in order, so each sees what the ones before it did.
+type Stages []Stage
+
+func (ss Stages) Process(msg irc.Message) error {
+	for _, s := range ss {
+		if err := s.Process(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (b *Bot) AddStage(s Stage) {
+	// Register now so the stage sees every message after it is added.
+	rc, dc := b.mc.NewReadChan()
 	b.wg.Add(1)
 	go func() {
 		defer b.wg.Done()
-		rc, dc := b.mc.NewReadChan()
 		defer close(dc)
 		for msg := range rc {
 			if err := s.Process(msg); err != nil {
@@ -120,11 +234,47 @@ func (b *Bot) AddStage(s Stage) {
 }
 
 func (b *Bot) Close() {
+	if b.Scheduler != nil {
+		b.Scheduler.Close()
+	}
+	if b.Timers != nil {
+		b.Timers.Close()
+	}
 	if b.Tasks != nil {
 		b.Tasks.Close()
 	}
 	b.cancel()
 	b.wg.Wait()
+	if b.Audit != nil {
+		b.Audit.Close()
+	}
+	if b.Recorder != nil {
+		b.Recorder.Close()
+	}
+}
+
+// Shutdown stops scheduling tasks, drains running tasks for up to drain,
+// then quits IRC with msg and closes the bot.
+func (b *Bot) Shutdown(drain time.Duration, msg string) {
+	b.Scheduler.Close()
+	b.Timers.Close()
+	b.Tasks.Drain(drain)
+	if err := b.mc.WriteMsg(irc.Message{Command: irc.QUIT, Params: []string{msg}}); err == nil {
+		// Wait for the server to close the connection.
+		select {
+		case <-b.mc.ctx.Done():
+		case <-time.After(quitWait):
+		}
+	}
+	b.Close()
+}
+
+// directive routes a control line from a task's output.
+func (b *Bot) directive(t *Task, tgt, l string) error {
+	if strings.HasPrefix(l, "dcc ") {
+		return b.Transfers.directive(t, tgt, l)
+	}
+	return b.Timers.directive(t, tgt, l)
 }
 
 func (b *Bot) Write(tid TaskId, msg irc.Message) error {
@@ -137,6 +287,9 @@ func (b *Bot) Write(tid TaskId, msg irc.Message) error {
 func (b *Bot) TxMsgs() uint64 { return b.mc.TxMsgs() }
 func (b *Bot) RxMsgs() uint64 { return b.mc.RxMsgs() }
 
+func (b *Bot) AddClients(n int64) { atomic.AddInt64(&b.clients, n) }
+func (b *Bot) Clients() int64     { return atomic.LoadInt64(&b.clients) }
+
 func (b *Bot) RLock()   { b.mu.RLock() }
 func (b *Bot) RUnlock() { b.mu.RUnlock() }
 
@@ -0,0 +1,235 @@
+package bot
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+// testBot connects a bot for p to s.
+func testBot(t *testing.T, s *irctest.Server, p Profile) (*Bot, *irctest.Client) {
+	p.ServerURL, p.RateMs = s.URL(), 1
+	botc := make(chan *Bot, 1)
+	go func() {
+		b, err := NewBot(context.Background(), p)
+		if err != nil {
+			t.Error(err)
+		}
+		botc <- b
+	}()
+	c := s.Client(t)
+	c.Welcomed(t)
+	b := <-botc
+	if b == nil {
+		t.FailNow()
+	}
+	t.Cleanup(b.Close)
+	for _, ch := range p.Chans {
+		c.Expect(t, irc.JOIN, ch)
+	}
+	return b, c
+}
+
+// fakeSandbox runs the test from a directory whose sandbox echoes the
+// script it was asked to run.
+func fakeSandbox(t *testing.T) {
+	writeSandbox(t, "s=$1\nshift\necho \"$s ran with $* for $SITBOT_FROM\"\n")
+}
+
+// writeSandbox runs the test from a directory whose sandbox is the shell
+// script sh.
+func writeSandbox(t *testing.T, sh string) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ScriptDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sb := "#!/bin/sh\n" + sh
+	if err := os.WriteFile(filepath.Join(dir, ScriptDir, "sandbox"), []byte(sb), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(irctest.Timeout); !f(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestDispatchPipeCmd(t *testing.T) {
+	fakeSandbox(t)
+	b, c := testBot(t, irctest.NewServer(t), Profile{ProfileLogin: ProfileLogin{Nick: "db"}, Chans: []string{"#t"},
+		Patterns: []Pattern{{Match: "^!echo (.*)", Template: "echo $1"}}})
+	c.Privmsg("alice", "#t", "!echo hi there")
+	c.Expect(t, irc.PRIVMSG, "#t", "echo ran with hi there for alice")
+	waitFor(t, "the task's rule", func() bool {
+		for _, ts := range b.Tasks.Finished() {
+			if ts.Rule == "Patterns:^!echo (.*)" {
+				return true
+			}
+		}
+		return false
+	})
+	c.Privmsg("bob", "db", "!echo private")
+	c.Expect(t, irc.PRIVMSG, "bob", "echo ran with private for bob")
+}
+
+func TestDispatchSession(t *testing.T) {
+	writeSandbox(t, "echo start\nwhile read l; do echo \"got $l\"; [ \"$l\" = bye ] && break; done\necho done\n")
+	_, c := testBot(t, irctest.NewServer(t), Profile{ProfileLogin: ProfileLogin{Nick: "sb"}, Chans: []string{"#t"},
+		Patterns: []Pattern{{Match: "^!game", Template: "game", SessionMs: 5000}, {Match: "^!echo", Template: "echo"}}})
+	// Lines sent before the script starts wait for it.
+	c.Privmsg("alice", "#t", "!game")
+	c.Privmsg("alice", "#t", "one")
+	c.Expect(t, irc.PRIVMSG, "#t", "start")
+	c.Expect(t, irc.PRIVMSG, "#t", "got one")
+	c.Privmsg("bob", "#t", "!echo")
+	c.Expect(t, irc.PRIVMSG, "#t", "start")
+	c.Expect(t, irc.PRIVMSG, "#t", "done")
+	c.Privmsg("alice", "#t", "bye")
+	c.Expect(t, irc.PRIVMSG, "#t", "got bye")
+	c.Expect(t, irc.PRIVMSG, "#t", "done")
+}
+
+func TestStateTracking(t *testing.T) {
+	s := irctest.NewServer(t)
+	s.AddUser("#s", "alice")
+	s.AddUser("#s", "@bob")
+	b, c := testBot(t, s, Profile{ProfileLogin: ProfileLogin{Nick: "sb"}, Chans: []string{"#s"}})
+	c.Join("carol", "#s")
+	c.Part("alice", "#s", "bye")
+	c.Send(":bob!bob@irctest NICK robert", ":carol!carol@irctest QUIT :gone")
+	want := map[string]string{"sb": "", "robert": "@"}
+	users := func() map[string]string {
+		b.State.RLock()
+		defer b.State.RUnlock()
+		ret := make(map[string]string)
+		if r := b.State.Channels["#s"]; r != nil {
+			for n, u := range r.Users {
+				ret[n] = u.Mode
+			}
+		}
+		return ret
+	}
+	waitFor(t, "channel users", func() bool { return reflect.DeepEqual(users(), want) })
+	chans := b.State.nickChannels("robert")
+	sort.Strings(chans)
+	if !reflect.DeepEqual(chans, []string{"#s"}) {
+		t.Errorf("robert in %q", chans)
+	}
+}
+
+func TestGangPost(t *testing.T) {
+	s := irctest.NewServer(t)
+	g := NewGang()
+	defer g.Shutdown(0, "bye")
+	p := Profile{Id: "g", ProfileLogin: ProfileLogin{ServerURL: s.URL(), Nick: "gb"}, Chans: []string{"#g"}, RateMs: 1}
+	if err := g.Post(p); err != nil {
+		t.Fatal(err)
+	}
+	c := s.Client(t)
+	c.Welcomed(t)
+	c.Expect(t, irc.JOIN, "#g")
+	waitFor(t, "bot online", func() bool { return g.Lookup("g") != nil })
+	b := g.Lookup("g")
+	if l := g.LookupLaunch("g"); l != nil {
+		t.Errorf("launch %+v still listed", l)
+	}
+
+	// Posting a running bot updates it in place.
+	p.Patterns = []Pattern{{Match: "^!x", Template: "x"}}
+	if err := g.Post(p); err != nil {
+		t.Fatal(err)
+	}
+	b.RLock()
+	n := len(b.Patterns)
+	b.RUnlock()
+	if g.Lookup("g") != b || n != 1 {
+		t.Fatalf("bot not updated in place")
+	}
+
+	if err := g.Delete("g"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-c.Closed():
+	case <-time.After(irctest.Timeout):
+		t.Fatal("deleted bot still connected")
+	}
+	if err := g.Delete("g"); err == nil {
+		t.Fatal("deleted bot twice")
+	}
+}
+
+func TestShutdownDrain(t *testing.T) {
+	writeSandbox(t, "while [ ! -f go ]; do sleep 0.01; done\necho \"$1 finished\"\n")
+	b, c := testBot(t, irctest.NewServer(t), Profile{ProfileLogin: ProfileLogin{Nick: "sd"}, Chans: []string{"#t"},
+		Patterns: []Pattern{{Match: "^!slow", Template: "slow"}, {Match: "^!late", Template: "late"}}})
+	c.Privmsg("alice", "#t", "!slow")
+	waitFor(t, "script running", func() bool { return !b.Tasks.idle() })
+	donec := make(chan struct{})
+	go func() {
+		b.Shutdown(irctest.Timeout, "bye")
+		close(donec)
+	}()
+	waitFor(t, "draining", func() bool {
+		b.Tasks.mu.RLock()
+		defer b.Tasks.mu.RUnlock()
+		return b.Tasks.draining
+	})
+	// The bot still answers the server while scripts finish.
+	c.Ping(t)
+	c.Privmsg("alice", "#t", "!late")
+	c.Ping(t)
+	if err := os.WriteFile("go", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.Expect(t, irc.PRIVMSG, "#t", "slow finished")
+	c.Expect(t, irc.QUIT, "bye")
+	<-donec
+	for _, msg := range c.Sent() {
+		if msg.Command == irc.PRIVMSG && msg.Params[len(msg.Params)-1] == "late finished" {
+			t.Errorf("script started while draining")
+		}
+	}
+}
+
+func TestGangLaunchFailed(t *testing.T) {
+	defer func(d time.Duration) { failedLaunchKeep = d }(failedLaunchKeep)
+	failedLaunchKeep = 100 * time.Millisecond
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	g := NewGang()
+	defer g.Shutdown(0, "bye")
+	p := Profile{Id: "f", ProfileLogin: ProfileLogin{ServerURL: "irc://" + ln.Addr().String(), Nick: "fb"}, RateMs: 1}
+	if err := g.Post(p); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "launch to fail", func() bool {
+		l := g.LookupLaunch("f")
+		return l != nil && l.Status == "failed" && l.Err != ""
+	})
+	waitFor(t, "failed launch to expire", func() bool { return g.LookupLaunch("f") == nil })
+}
@@ -0,0 +1,121 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/dcc"
+)
+
+// Chat offers nick an admin console over DCC CHAT. The password for the
+// console is sent to nick by notice, so only nick can use the offer.
+func (d *DCC) Chat(nick string) error {
+	cfg := d.config()
+	if cfg == nil {
+		return fmt.Errorf("dcc not configured")
+	}
+	o, err := d.offer(cfg, nick, &dcc.Request{Cmd: "CHAT", File: "chat"})
+	if err != nil {
+		return err
+	}
+	pass := chatPassword()
+	msg := irc.Message{Command: irc.NOTICE, Params: []string{nick, "DCC CHAT password: " + pass}}
+	if err := d.b.mc.WriteMsg(msg); err != nil {
+		o.close()
+		return err
+	}
+	err = d.runChat("DCC CHAT to "+nick, nick, "", pass, func(ctx context.Context) (net.Conn, error) {
+		defer o.close()
+		cctx, cancel := context.WithTimeout(ctx, cfg.Timeout())
+		defer cancel()
+		return o.connect(cctx)
+	})
+	if err != nil {
+		o.close()
+	}
+	return err
+}
+
+// acceptChat connects to an owner's chat offer.
+func (d *DCC) acceptChat(cfg *dcc.Config, from *irc.Prefix, r *dcc.Request) error {
+	return d.runChat("DCC CHAT from "+from.Name, from.Name, from.String(), "", func(ctx context.Context) (net.Conn, error) {
+		cctx, cancel := context.WithTimeout(ctx, cfg.Timeout())
+		defer cancel()
+		if r.Port == 0 {
+			return d.listenReverse(cctx, cfg, from.Name, r)
+		}
+		var dialer net.Dialer
+		return dialer.DialContext(cctx, "tcp", r.Addr())
+	})
+}
+
+func chatPassword() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// runChat runs a console task over the connection from connect. If pass
+// is set, the peer must send it as the first line.
+func (d *DCC) runChat(cmd, nick, from, pass string, connect func(context.Context) (net.Conn, error)) error {
+	return d.b.Tasks.RunLimited("dcc chat", cmd, Limits{}, func(t *Task) error {
+		t.mu.Lock()
+		t.From, t.Target = from, nick
+		t.mu.Unlock()
+		conn, err := connect(t.ctx)
+		if err != nil {
+			return err
+		}
+		stop := context.AfterFunc(t.ctx, func() { conn.Close() })
+		defer stop()
+		defer conn.Close()
+		log.Printf("[dcc] console for %s from %s", nick, conn.RemoteAddr())
+		var mu sync.Mutex
+		out := func(l string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			_, err := io.WriteString(conn, l+"\n")
+			return err
+		}
+		s := bufio.NewScanner(conn)
+		if pass != "" {
+			if err := out("password:"); err != nil {
+				return err
+			}
+			if !s.Scan() || subtle.ConstantTimeCompare([]byte(strings.TrimRight(s.Text(), "\r")), []byte(pass)) != 1 {
+				out("bad password")
+				return fmt.Errorf("bad console password from %s", conn.RemoteAddr())
+			}
+		}
+		c := NewConsole(d.b, out)
+		defer c.Close()
+		if err := out("sitbot console; try help"); err != nil {
+			return err
+		}
+		for s.Scan() {
+			err := c.Exec(strings.TrimRight(s.Text(), "\r"))
+			if err == ErrConsoleQuit {
+				return nil
+			} else if err != nil {
+				if err := out("error: " + err.Error()); err != nil {
+					return err
+				}
+			}
+		}
+		if t.ctx.Err() != nil {
+			return nil
+		}
+		return s.Err()
+	})
+}
@@ -0,0 +1,59 @@
+package bot
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/dcc"
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+// chatOffer has the bot offer alice a console and connects to it.
+func chatOffer(t *testing.T, b *Bot, c *irctest.Client) (*bufio.Reader, net.Conn, string) {
+	t.Helper()
+	if err := b.Transfers.Chat("alice"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := dcc.Parse(c.Expect(t, irc.PRIVMSG, "alice").Params[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass, ok := strings.CutPrefix(c.Expect(t, irc.NOTICE, "alice").Params[1], "DCC CHAT password: ")
+	if !ok {
+		t.Fatal("expected password notice")
+	}
+	conn, err := net.Dial("tcp", r.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	rd := bufio.NewReader(conn)
+	if l, _ := rd.ReadString('\n'); l != "password:\n" {
+		t.Fatalf("got prompt %q", l)
+	}
+	return rd, conn, pass
+}
+
+func TestChatPassword(t *testing.T) {
+	b, c := testBot(t, irctest.NewServer(t), Profile{ProfileLogin: ProfileLogin{Nick: "cb"},
+		DCC: &dcc.Config{ExternalIP: "127.0.0.1"}})
+
+	rd, conn, _ := chatOffer(t, b, c)
+	conn.Write([]byte("guess\n"))
+	if l, _ := rd.ReadString('\n'); l != "bad password\n" {
+		t.Errorf("got %q for wrong password", l)
+	}
+	if _, err := rd.ReadString('\n'); err == nil {
+		t.Errorf("expected console to hang up")
+	}
+
+	rd, conn, pass := chatOffer(t, b, c)
+	conn.Write([]byte(pass + "\r\n"))
+	if l, _ := rd.ReadString('\n'); !strings.HasPrefix(l, "sitbot console") {
+		t.Errorf("got %q for right password", l)
+	}
+}
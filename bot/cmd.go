@@ -14,22 +14,51 @@ type Cmd struct {
 	linec  chan string
 	err    error
 	closer io.Closer
+	stdin  io.WriteCloser
+	state  *os.ProcessState
 }
 
+// NewCmd starts a command reading from /dev/null.
 func NewCmd(ctx context.Context, cmdname string, args []string, env []string) (*Cmd, error) {
-	donec, linec := make(chan struct{}), make(chan string, 5)
+	return startCmd(ctx, newExecCmd(ctx, cmdname, args, env), false)
+}
+
+// NewSessionCmd starts a command with its stdin piped from Stdin.
+func NewSessionCmd(ctx context.Context, cmdname string, args []string, env []string) (*Cmd, error) {
+	return startCmd(ctx, newExecCmd(ctx, cmdname, args, env), true)
+}
+
+func newExecCmd(ctx context.Context, cmdname string, args []string, env []string) *exec.Cmd {
 	cmd := exec.CommandContext(ctx, cmdname, args...)
-	cmd.Stderr = os.Stderr
 	cmd.Env = append(os.Environ(), env...)
+	return cmd
+}
+
+// StartCmd starts a configured command with its stdin piped; ctx should be
+// the command's context.
+func StartCmd(ctx context.Context, cmd *exec.Cmd) (*Cmd, error) {
+	return startCmd(ctx, cmd, true)
+}
+
+func startCmd(ctx context.Context, cmd *exec.Cmd, pipeStdin bool) (*Cmd, error) {
+	donec, linec := make(chan struct{}), make(chan string, 5)
+	cmd.Stderr = os.Stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
 		log.Println(err)
 		return nil, err
 	}
+	var stdin io.WriteCloser
+	if pipeStdin {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			stdout.Close()
+			return nil, err
+		}
+	}
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
-	c := &Cmd{donec: donec, linec: linec, closer: stdout}
+	c := &Cmd{donec: donec, linec: linec, closer: stdout, stdin: stdin}
 	lr := bufio.NewReader(stdout)
 	go func() {
 		defer func() {
@@ -38,6 +67,7 @@ func NewCmd(ctx context.Context, cmdname string, args []string, env []string) (*
 			if err := cmd.Wait(); c.err == nil {
 				c.err = err
 			}
+			c.state = cmd.ProcessState
 			close(donec)
 		}()
 		for {
@@ -62,7 +92,17 @@ func NewCmd(ctx context.Context, cmdname string, args []string, env []string) (*
 
 func (c *Cmd) Lines() <-chan string { return c.linec }
 
+// Stdin is the command's piped standard input, if any; close it to signal
+// EOF.
+func (c *Cmd) Stdin() io.WriteCloser { return c.stdin }
+
+// ProcessState is the exited command's state; only valid after Close.
+func (c *Cmd) ProcessState() *os.ProcessState { return c.state }
+
 func (c *Cmd) Close() error {
+	if c.stdin != nil {
+		c.stdin.Close()
+	}
 	c.closer.Close()
 	<-c.donec
 	return c.err
@@ -13,29 +13,92 @@ import (
 )
 
 type MsgConnStats struct {
-	txMsgs uint64
-	rxMsgs uint64
+	txMsgs  uint64
+	rxMsgs  uint64
+	txBytes uint64
+	rxBytes uint64
+	// limitNs is the time spent waiting on the write rate limit.
+	limitNs uint64
+	txCmds  cmdCounts
+	rxCmds  cmdCounts
 }
 
 func (m *MsgConnStats) TxMsgs() uint64 { return atomic.LoadUint64(&m.txMsgs) }
 func (m *MsgConnStats) RxMsgs() uint64 { return atomic.LoadUint64(&m.rxMsgs) }
 
+func (m *MsgConnStats) TxBytes() uint64 { return atomic.LoadUint64(&m.txBytes) }
+func (m *MsgConnStats) RxBytes() uint64 { return atomic.LoadUint64(&m.rxBytes) }
+
+func (m *MsgConnStats) LimitWait() time.Duration {
+	return time.Duration(atomic.LoadUint64(&m.limitNs))
+}
+
+// TxCmds and RxCmds count messages by command.
+func (m *MsgConnStats) TxCmds() map[string]uint64 { return m.txCmds.snapshot() }
+func (m *MsgConnStats) RxCmds() map[string]uint64 { return m.rxCmds.snapshot() }
+
+func (m *MsgConnStats) rx(msg *irc.Message) {
+	atomic.AddUint64(&m.rxMsgs, 1)
+	atomic.AddUint64(&m.rxBytes, uint64(msg.Len()+2))
+	m.rxCmds.add(msg.Command)
+}
+
+func (m *MsgConnStats) tx(msg *irc.Message) {
+	atomic.AddUint64(&m.txMsgs, 1)
+	atomic.AddUint64(&m.txBytes, uint64(msg.Len()+2))
+	m.txCmds.add(msg.Command)
+}
+
+type cmdCounts struct {
+	m  map[string]uint64
+	mu sync.Mutex
+}
+
+func (c *cmdCounts) add(cmd string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]uint64)
+	}
+	c.m[cmd]++
+}
+
+func (c *cmdCounts) snapshot() map[string]uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := make(map[string]uint64, len(c.m))
+	for k, v := range c.m {
+		ret[k] = v
+	}
+	return ret
+}
+
 type MsgConn struct {
-	*irc.Conn
+	Transport
 	MsgConnStats
 	ctx    context.Context
 	wg     sync.WaitGroup
 	readc  chan irc.Message
 	writec chan irc.Message
+	// txTaps get copies of sent messages, dropped if the tap is full.
+	txTaps map[chan irc.Message]struct{}
+	// txHook gets every sent message, in order.
+	txHook *txHook
+	tapMu  sync.Mutex
 }
 
 func NewMsgConn(ctx context.Context, conn net.Conn, invl time.Duration) (*MsgConn, error) {
+	return NewTransportMsgConn(ctx, NewIRCTransport(conn), invl)
+}
+
+// NewTransportMsgConn is NewMsgConn over any Transport.
+func NewTransportMsgConn(ctx context.Context, tr Transport, invl time.Duration) (*MsgConn, error) {
 	cctx, cancel := context.WithCancel(ctx)
 	mc := &MsgConn{
-		Conn:   irc.NewConn(conn),
-		ctx:    cctx,
-		readc:  make(chan irc.Message, 16),
-		writec: make(chan irc.Message),
+		Transport: tr,
+		ctx:       cctx,
+		readc:     make(chan irc.Message, 16),
+		writec:    make(chan irc.Message),
 	}
 	mc.wg.Add(2)
 	stopf := func() {
@@ -50,36 +113,40 @@ func NewMsgConn(ctx context.Context, conn net.Conn, invl time.Duration) (*MsgCon
 		for {
 			msg, err := mc.Decode()
 			if err != nil {
-				mc.Conn.Close()
+				mc.Transport.Close()
 				return
 			}
 			if msg == nil {
-				log.Printf("got nil message on %s", conn.RemoteAddr().String())
+				log.Printf("got nil message on %s", transportAddr(tr))
 				continue
 			}
 			select {
 			case mc.readc <- *msg:
-				atomic.AddUint64(&mc.rxMsgs, 1)
+				mc.rx(msg)
 			case <-mc.ctx.Done():
 			}
 		}
 	}()
 	go func() {
 		defer func() {
-			mc.Conn.Close()
+			mc.Transport.Close()
 			stopf()
 		}()
 		l := rate.NewLimiter(rate.Every(invl), 1)
 		for {
-			if err := l.Wait(mc.ctx); err != nil {
-				return
-			}
 			select {
 			case msg := <-mc.writec:
-				atomic.AddUint64(&mc.txMsgs, 1)
+				// Only count waits with a message pending.
+				start := time.Now()
+				if err := l.Wait(mc.ctx); err != nil {
+					return
+				}
+				atomic.AddUint64(&mc.limitNs, uint64(time.Since(start)))
+				mc.tx(&msg)
 				if mc.Encode(&msg) != nil {
 					return
 				}
+				mc.tap(msg)
 			case <-mc.ctx.Done():
 				return
 			}
@@ -99,8 +166,114 @@ func (mc *MsgConn) WriteMsg(m irc.Message) error {
 
 func (mc *MsgConn) ReadChan() <-chan irc.Message { return mc.readc }
 
+// TapTx returns a channel copying sent messages and a function to stop it.
+func (mc *MsgConn) TapTx() (<-chan irc.Message, func()) {
+	c := make(chan irc.Message, 16)
+	mc.tapMu.Lock()
+	if mc.txTaps == nil {
+		mc.txTaps = make(map[chan irc.Message]struct{})
+	}
+	mc.txTaps[c] = struct{}{}
+	mc.tapMu.Unlock()
+	return c, func() {
+		mc.tapMu.Lock()
+		delete(mc.txTaps, c)
+		mc.tapMu.Unlock()
+	}
+}
+
+// SetTxHook has f called with each sent message and when it was sent, in
+// order, from a goroutine of its own so a slow hook never delays sending.
+// It replaces any earlier hook; nil removes it. Once SetTxHook returns, the
+// old hook has seen every message sent before it and is no longer running.
+func (mc *MsgConn) SetTxHook(f func(irc.Message, time.Time)) {
+	var h *txHook
+	if f != nil {
+		h = newTxHook(f)
+	}
+	mc.tapMu.Lock()
+	old := mc.txHook
+	mc.txHook = h
+	mc.tapMu.Unlock()
+	if old != nil {
+		old.close()
+	}
+}
+
+func (mc *MsgConn) tap(msg irc.Message) {
+	mc.tapMu.Lock()
+	defer mc.tapMu.Unlock()
+	if mc.txHook != nil {
+		mc.txHook.push(msg)
+	}
+	for c := range mc.txTaps {
+		select {
+		case c <- msg:
+		default:
+		}
+	}
+}
+
+// txHook queues sent messages without bound for a hook's goroutine.
+type txHook struct {
+	f      func(irc.Message, time.Time)
+	q      []sentMsg
+	closed bool
+	mu     sync.Mutex
+	cond   *sync.Cond
+	donec  chan struct{}
+}
+
+type sentMsg struct {
+	msg irc.Message
+	t   time.Time
+}
+
+func newTxHook(f func(irc.Message, time.Time)) *txHook {
+	h := &txHook{f: f, donec: make(chan struct{})}
+	h.cond = sync.NewCond(&h.mu)
+	go h.run()
+	return h
+}
+
+func (h *txHook) push(msg irc.Message) {
+	h.mu.Lock()
+	h.q = append(h.q, sentMsg{msg, time.Now()})
+	h.mu.Unlock()
+	h.cond.Signal()
+}
+
+func (h *txHook) run() {
+	defer close(h.donec)
+	h.mu.Lock()
+	for {
+		for len(h.q) == 0 && !h.closed {
+			h.cond.Wait()
+		}
+		q := h.q
+		h.q = nil
+		h.mu.Unlock()
+		if len(q) == 0 {
+			return
+		}
+		for _, m := range q {
+			h.f(m.msg, m.t)
+		}
+		h.mu.Lock()
+	}
+}
+
+// close waits for the hook to see every queued message, then stops it.
+func (h *txHook) close() {
+	h.mu.Lock()
+	h.closed = true
+	h.mu.Unlock()
+	h.cond.Signal()
+	<-h.donec
+}
+
 func (mc *MsgConn) Close() error {
-	err := mc.Conn.Close()
+	err := mc.Transport.Close()
 	mc.wg.Wait()
 	return err
 }
@@ -116,8 +289,8 @@ type readChan struct {
 	donec <-chan struct{}
 }
 
-func NewTeeMsgConn(ctx context.Context, conn net.Conn, ms int) (*TeeMsgConn, error) {
-	mc, err := NewMsgConn(ctx, conn, time.Duration(ms)*time.Millisecond)
+func NewTeeMsgConn(ctx context.Context, tr Transport, ms int) (*TeeMsgConn, error) {
+	mc, err := NewTransportMsgConn(ctx, tr, time.Duration(ms)*time.Millisecond)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,274 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// ErrConsoleQuit is returned by Exec when the user ends the console.
+var ErrConsoleQuit = errors.New("console quit")
+
+var consoleHelp = []string{
+	"tasks                 list running tasks",
+	"kill <tid>            kill a task",
+	"reload                reload profiles",
+	"join <chan>           join a channel",
+	"part <chan> [msg]     leave a channel",
+	"say <target> <text>   send a message",
+	"raw <line>            send a raw IRC line",
+	"stats                 show traffic and task counts",
+	"bouncer [addr]        show bouncer clients or start a bouncer on addr",
+	"tail [off]            show the bot's IRC traffic",
+	"quit                  close the console",
+}
+
+// consoleDCCOnly are commands that only make sense over DCC CHAT.
+var consoleDCCOnly = map[string]bool{"tail": true, "quit": true}
+
+// Console runs line-based admin commands on a bot, writing replies with out.
+type Console struct {
+	b   *Bot
+	out func(string) error
+	// overIRC is set when replies go over IRC, so there is no tail or quit.
+	overIRC bool
+	// stopTail stops the running tail, if any.
+	stopTail func()
+	mu       sync.Mutex
+}
+
+func NewConsole(b *Bot, out func(string) error) *Console {
+	return &Console{b: b, out: out}
+}
+
+// IsOwner reports whether a user's prefix matches one of the profile's Owners.
+func (b *Bot) IsOwner(pfx *irc.Prefix) bool {
+	if pfx == nil {
+		return false
+	}
+	b.mu.RLock()
+	owners := b.Owners
+	b.mu.RUnlock()
+	for _, o := range owners {
+		if MatchMask(o, pfx.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload reloads the gang's profiles from their source.
+func (b *Bot) Reload() error {
+	if b.gang == nil || b.gang.Reload == nil {
+		return errors.New("no profile source to reload")
+	}
+	return b.gang.Reload()
+}
+
+// Exec runs a command line.
+func (c *Console) Exec(l string) error {
+	cmd, arg, _ := strings.Cut(strings.TrimSpace(l), " ")
+	arg = strings.TrimSpace(arg)
+	b := c.b
+	cmd = strings.ToLower(cmd)
+	if c.overIRC && consoleDCCOnly[cmd] {
+		return fmt.Errorf("%s only works over DCC CHAT", cmd)
+	}
+	switch cmd {
+	case "":
+		return nil
+	case "help":
+		for _, h := range consoleHelp {
+			if c.overIRC && consoleDCCOnly[strings.Fields(h)[0]] {
+				continue
+			}
+			if err := c.out(h); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "tasks":
+		return c.tasks()
+	case "kill":
+		tid, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad task id %q", arg)
+		}
+		if err := b.Tasks.Kill(TaskId(tid)); err != nil {
+			return fmt.Errorf("no task %d", tid)
+		}
+		return c.out(fmt.Sprintf("killed %d", tid))
+	case "reload":
+		if err := b.Reload(); err != nil {
+			return err
+		}
+		return c.out("reloaded")
+	case "join":
+		if arg == "" {
+			return errors.New("join needs a channel")
+		}
+		return b.Write(0, irc.Message{Command: irc.JOIN, Params: []string{arg}})
+	case "part":
+		ch, msg, _ := strings.Cut(arg, " ")
+		if ch == "" {
+			return errors.New("part needs a channel")
+		}
+		m := irc.Message{Command: irc.PART, Params: []string{ch}}
+		if msg != "" {
+			m.Params = append(m.Params, msg)
+		}
+		return b.Write(0, m)
+	case "say":
+		tgt, txt, _ := strings.Cut(arg, " ")
+		if tgt == "" || txt == "" {
+			return errors.New("say needs a target and text")
+		}
+		return b.Write(0, irc.Message{Command: irc.PRIVMSG, Params: []string{tgt, txt}})
+	case "raw":
+		m := irc.ParseMessage(arg)
+		if m == nil {
+			return fmt.Errorf("bad raw line %q", arg)
+		}
+		return b.Write(0, *m)
+	case "stats":
+		return c.stats()
+	case "bouncer":
+		if arg == "" {
+			return c.out(fmt.Sprintf("%d bouncer clients", b.Clients()))
+		} else if b.gang == nil || b.gang.Bouncer == nil {
+			return errors.New("no bouncer support")
+		} else if err := b.gang.Bouncer(b, arg); err != nil {
+			return err
+		}
+		return c.out("bouncer listening on " + arg)
+	case "tail":
+		if arg == "off" {
+			c.Close()
+			return nil
+		}
+		c.tail()
+		return nil
+	case "quit":
+		return ErrConsoleQuit
+	}
+	return fmt.Errorf("unknown command %q; try help", cmd)
+}
+
+func (c *Console) tasks() error {
+	ts := c.b.Tasks
+	ts.mu.RLock()
+	tids := make([]TaskId, 0, len(ts.Tasks))
+	for tid := range ts.Tasks {
+		tids = append(tids, tid)
+	}
+	sort.Slice(tids, func(i, j int) bool { return tids[i] < tids[j] })
+	lines := make([]string, 0, len(tids))
+	for _, tid := range tids {
+		t := ts.Tasks[tid]
+		l := fmt.Sprintf("%d %s (%v)", tid, t.Name, t.Start.Elapsed())
+		t.mu.Lock()
+		if t.Progress != nil {
+			l += " " + t.Progress.String()
+		}
+		t.mu.Unlock()
+		lines = append(lines, l)
+	}
+	ts.mu.RUnlock()
+	if len(lines) == 0 {
+		return c.out("no tasks")
+	}
+	for _, l := range lines {
+		if err := c.out(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Console) stats() error {
+	m := c.b.metrics("")
+	b := c.b
+	ls := []string{
+		fmt.Sprintf("up %v, %d channels, %d users, %d bouncer clients",
+			b.Start.Elapsed(), m.channels, m.users, m.clients),
+		fmt.Sprintf("rx %d msgs %d bytes, tx %d msgs %d bytes, %v send wait",
+			b.RxMsgs(), m.rxBytes, b.TxMsgs(), m.txBytes, m.sendWait.Round(time.Millisecond)),
+		fmt.Sprintf("%d tasks running, %d started", m.running, m.total),
+	}
+	for _, l := range ls {
+		if err := c.out(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tail copies the bot's traffic to the console until Close. Lines are
+// dropped if the console falls behind so it never stalls the bot.
+func (c *Console) tail() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopTail != nil {
+		return
+	}
+	mc := c.b.mc
+	rc, dc := mc.NewReadChan()
+	txc, stopTx := mc.TapTx()
+	stopc := make(chan struct{})
+	linec := make(chan string, 64)
+	c.stopTail = func() {
+		close(stopc)
+		stopTx()
+		close(dc)
+		mc.DropReadChan(rc)
+	}
+	send := func(l string) {
+		select {
+		case linec <- l:
+		default:
+		}
+	}
+	go func() {
+		for {
+			select {
+			case msg, ok := <-rc:
+				if !ok {
+					return
+				}
+				send("<- " + msg.String())
+			case msg := <-txc:
+				send("-> " + msg.String())
+			case <-stopc:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case l := <-linec:
+				if c.out(l) != nil {
+					return
+				}
+			case <-stopc:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops any tail.
+func (c *Console) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopTail != nil {
+		c.stopTail()
+		c.stopTail = nil
+	}
+}
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"log"
+	"strings"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// controlQueueLen bounds owner commands waiting to run.
+const controlQueueLen = 16
+
+// controlLine returns the command in txt if it starts with the control
+// prefix, allowing a ':' or ',' after it as when addressing a nick. The
+// prefix defaults to the bot's nick on the server.
+func (b *Bot) controlLine(txt string) (string, bool) {
+	b.mu.RLock()
+	pfx, owners := b.ControlPrefix, len(b.Owners)
+	if pfx == "" {
+		pfx = b.Nick
+		if b.Login != nil && b.Login.CurrentNick() != "" {
+			pfx = b.Login.CurrentNick()
+		}
+	}
+	b.mu.RUnlock()
+	if owners == 0 || len(txt) < len(pfx) || !strings.EqualFold(txt[:len(pfx)], pfx) {
+		return "", false
+	}
+	rest := strings.TrimLeft(txt[len(pfx):], ":,")
+	if rest != "" && rest[0] != ' ' {
+		// Some longer word starting with the prefix.
+		return "", false
+	}
+	if rest = strings.TrimSpace(rest); rest == "" {
+		rest = "help"
+	}
+	return rest, true
+}
+
+// control queues an owner's command, reporting whether msg was one. Other
+// users' messages go on to the patterns.
+func (b *Bot) control(msg irc.Message) bool {
+	l, ok := b.controlLine(msg.Params[1])
+	if !ok || !b.IsOwner(msg.Prefix) {
+		return false
+	}
+	select {
+	case b.controlc <- msg:
+	default:
+		log.Printf("[control] dropping %q from %s", l, msg.Prefix)
+	}
+	return true
+}
+
+// runControl runs queued owner commands in order as tasks, replying by
+// NOTICE.
+func (b *Bot) runControl() {
+	defer b.wg.Done()
+	for {
+		var msg irc.Message
+		select {
+		case msg = <-b.controlc:
+		case <-b.ctx.Done():
+			return
+		}
+		l, _ := b.controlLine(msg.Params[1])
+		nick := msg.Prefix.Name
+		out := func(s string) error {
+			return b.mc.WriteMsg(irc.Message{Command: irc.NOTICE, Params: []string{nick, s}})
+		}
+		t, err := b.Tasks.run("control", l, Limits{}, false, func(t *Task) error {
+			t.mu.Lock()
+			t.From, t.Target = msg.Prefix.String(), nick
+			t.mu.Unlock()
+			c := &Console{b: b, out: out, overIRC: true}
+			if err := c.Exec(l); err != nil {
+				return out("error: " + err.Error())
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("[control] could not run %q from %s (%v)", l, msg.Prefix, err)
+			continue
+		}
+		<-t.donec
+	}
+}
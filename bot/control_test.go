@@ -0,0 +1,45 @@
+package bot
+
+import (
+	"testing"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestControlLine(t *testing.T) {
+	b := &Bot{Profile: Profile{ProfileLogin: ProfileLogin{Nick: "sitbot"}, Owners: []string{"*!*@admin"}}}
+	tests := []struct {
+		txt, l string
+		ok     bool
+	}{
+		{"sitbot: join #x", "join #x", true},
+		{"SITBOT, tasks", "tasks", true},
+		{"sitbot", "help", true},
+		{"sitbotx tasks", "", false},
+		{"hi sitbot", "", false},
+	}
+	for _, tt := range tests {
+		if l, ok := b.controlLine(tt.txt); l != tt.l || ok != tt.ok {
+			t.Errorf("%q: got %q %v", tt.txt, l, ok)
+		}
+	}
+	// Under a fallback nick, the prefix follows the nick on the server.
+	f, _ := NewFormatter("")
+	b.Login = NewLogin(&b.ProfileLogin, &Tasks{fmtr: f})
+	b.Login.Process(irc.Message{Prefix: &irc.Prefix{Name: "srv"}, Command: irc.RPL_WELCOME, Params: []string{"sitbot_", "hi"}})
+	if l, ok := b.controlLine("sitbot_: tasks"); !ok || l != "tasks" {
+		t.Errorf("got %q %v under fallback nick", l, ok)
+	}
+	b.Login.Process(irc.Message{Prefix: &irc.Prefix{Name: "sitbot_"}, Command: irc.NICK, Params: []string{"sitbot"}})
+	if l, ok := b.controlLine("sitbot: tasks"); !ok || l != "tasks" {
+		t.Errorf("got %q %v after reclaiming nick", l, ok)
+	}
+	b.ControlPrefix = "!ctl"
+	if l, ok := b.controlLine("!ctl kill 3"); !ok || l != "kill 3" {
+		t.Errorf("got %q %v", l, ok)
+	}
+	b.Owners = nil
+	if _, ok := b.controlLine("!ctl kill 3"); ok {
+		t.Errorf("expected no control without owners")
+	}
+}
@@ -0,0 +1,119 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five field cron expression (minute, hour, day of month,
+// month, day of week).
+type Cron struct {
+	min, hour, dom, month, dow uint64
+	// domStar and dowStar are set for unrestricted days, whether written
+	// as "*", "*/1", or a full range, so only the other day field needs
+	// to match.
+	domStar, dowStar bool
+}
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// allDays and allWeekdays are the bits of unrestricted day fields.
+const allDays, allWeekdays = 1<<32 - 2, 1<<7 - 1
+
+func ParseCron(s string) (*Cron, error) {
+	if a, ok := cronAliases[s]; ok {
+		s = a
+	}
+	fs := strings.Fields(s)
+	if len(fs) != 5 {
+		return nil, fmt.Errorf("cron %q: expected 5 fields", s)
+	}
+	c := &Cron{}
+	var err error
+	for i, f := range []struct {
+		v        *uint64
+		min, max int
+	}{{&c.min, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}} {
+		if *f.v, err = parseCronField(fs[i], f.min, f.max); err != nil {
+			return nil, fmt.Errorf("cron %q: %v", s, err)
+		}
+	}
+	// Sunday is both 0 and 7.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domStar, c.dowStar = c.dom == allDays, c.dow&allWeekdays == allWeekdays
+	return c, nil
+}
+
+func parseCronField(s string, min, max int) (bits uint64, err error) {
+	for _, r := range strings.Split(s, ",") {
+		rng, step, hasStep := strings.Cut(r, "/")
+		lo, hi, n := min, max, 1
+		if hasStep {
+			if n, err = strconv.Atoi(step); err != nil || n <= 0 {
+				return 0, fmt.Errorf("bad step %q", r)
+			}
+		}
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("bad value %q", r)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("bad value %q", r)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", r, min, max)
+		}
+		for i := lo; i <= hi; i += n {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom, dow := c.dom&(1<<uint(t.Day())) != 0, c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t matching the expression.
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Give up after five years, such as for February 30th.
+	for end := t.AddDate(5, 0, 0); t.Before(end); {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.min&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,41 @@
+package bot
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	start := time.Date(2024, time.January, 31, 23, 59, 30, 0, time.UTC)
+	tt := []struct {
+		expr string
+		next time.Time
+	}{
+		{"@hourly", time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+		{"*/15 9-17 * * 1-5", time.Date(2024, time.February, 1, 9, 0, 0, 0, time.UTC)},
+		{"30 12 29 2 *", time.Date(2024, time.February, 29, 12, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, time.February, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		// Unrestricted day fields only need the other field to match.
+		{"0 0 */1 * 5", time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1-31 * 5", time.Date(2024, time.February, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 0-7", time.Date(2024, time.February, 13, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tc := range tt {
+		c, err := ParseCron(tc.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.expr, err)
+		}
+		if next := c.Next(start); !next.Equal(tc.next) {
+			t.Errorf("%q: expected %v, got %v", tc.expr, tc.next, next)
+		}
+	}
+}
+
+func TestCronBad(t *testing.T) {
+	for _, expr := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package bot
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+const (
+	defaultCTCPVersion = "sitbot"
+	defaultCTCPSource  = "https://github.com/chzchzchz/sitbot"
+	defaultCTCPRateMs  = 2000
+	ctcpBurst          = 3
+)
+
+// ctcpAnswered are the CTCP queries answered by the CTCP stage instead of
+// being matched against patterns.
+var ctcpAnswered = map[string]bool{
+	"CLIENTINFO": true, "PING": true, "SOURCE": true, "TIME": true, "VERSION": true,
+}
+
+// CTCPConfig sets the bot's CTCP replies; empty fields use defaults.
+type CTCPConfig struct {
+	Version string `json:",omitempty"`
+	Source  string `json:",omitempty"`
+	// TimeFormat is a Go time layout for TIME replies.
+	TimeFormat string `json:",omitempty"`
+	// RateMs is the time between replies, allowing short bursts.
+	RateMs int `json:",omitempty"`
+}
+
+// ctcpSplit splits a CTCP message into its upper case type and arguments.
+func ctcpSplit(txt string) (typ, args string, ok bool) {
+	if len(txt) < 2 || txt[0] != '\x01' {
+		return "", "", false
+	}
+	typ, args, _ = strings.Cut(strings.TrimSuffix(txt[1:], "\x01"), " ")
+	return strings.ToUpper(typ), args, typ != ""
+}
+
+// patternText returns the pattern type and text a PRIVMSG is matched
+// as, or false if the CTCP stage answers it.
+func patternText(txt string) (typ, ptxt string, ok bool) {
+	ctyp, args, isCTCP := ctcpSplit(txt)
+	switch {
+	case !isCTCP:
+		return "", txt, true
+	case ctyp == "ACTION":
+		return PatternAction, args, true
+	case ctcpAnswered[ctyp]:
+		return "", "", false
+	}
+	return PatternCTCP, strings.TrimSpace(ctyp + " " + args), true
+}
+
+// CTCP answers CTCP queries with NOTICEs.
+type CTCP struct {
+	b       *Bot
+	limiter *rate.Limiter
+}
+
+func NewCTCP(b *Bot) *CTCP {
+	c := &CTCP{b: b, limiter: rate.NewLimiter(0, ctcpBurst)}
+	c.update(b.CTCP)
+	return c
+}
+
+func (c *CTCP) update(cfg *CTCPConfig) {
+	ms := defaultCTCPRateMs
+	if cfg != nil && cfg.RateMs > 0 {
+		ms = cfg.RateMs
+	}
+	c.limiter.SetLimit(rate.Every(time.Duration(ms) * time.Millisecond))
+}
+
+func (c *CTCP) reply(typ, args string) string {
+	c.b.mu.RLock()
+	cfg := CTCPConfig{}
+	if c.b.CTCP != nil {
+		cfg = *c.b.CTCP
+	}
+	c.b.mu.RUnlock()
+	switch typ {
+	case "VERSION":
+		if cfg.Version == "" {
+			return defaultCTCPVersion
+		}
+		return cfg.Version
+	case "SOURCE":
+		if cfg.Source == "" {
+			return defaultCTCPSource
+		}
+		return cfg.Source
+	case "TIME":
+		if cfg.TimeFormat == "" {
+			return time.Now().Format(time.RFC1123)
+		}
+		return time.Now().Format(cfg.TimeFormat)
+	case "PING":
+		return args
+	case "CLIENTINFO":
+		return "ACTION CLIENTINFO DCC PING SOURCE TIME VERSION"
+	}
+	return ""
+}
+
+func (c *CTCP) Process(msg irc.Message) error {
+	// Only answer queries; replies come back as NOTICEs.
+	if msg.Command != irc.PRIVMSG || msg.Prefix == nil || len(msg.Params) < 2 {
+		return nil
+	}
+	typ, args, ok := ctcpSplit(msg.Params[1])
+	if !ok || !ctcpAnswered[typ] {
+		return nil
+	}
+	if !c.limiter.Allow() {
+		log.Printf("[ctcp] dropping %s from %s", typ, msg.Prefix)
+		return nil
+	}
+	r := typ
+	if s := c.reply(typ, args); s != "" {
+		r += " " + s
+	}
+	out := irc.Message{Command: irc.NOTICE, Params: []string{msg.Prefix.Name, "\x01" + r + "\x01"}}
+	if err := c.b.mc.WriteMsg(out); err != nil {
+		log.Printf("[ctcp] failed replying to %s (%v)", msg.Prefix, err)
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package bot
+
+import "testing"
+
+func TestPatternText(t *testing.T) {
+	tests := []struct{ txt, typ, ptxt string }{
+		{"hello", "", "hello"},
+		{"\x01ACTION waves\x01", PatternAction, "waves"},
+		{"\x01FINGER\x01", PatternCTCP, "FINGER"},
+		{"\x01dcc SEND f 1 2 3", PatternCTCP, "DCC SEND f 1 2 3"},
+	}
+	for _, tt := range tests {
+		typ, ptxt, ok := patternText(tt.txt)
+		if !ok || typ != tt.typ || ptxt != tt.ptxt {
+			t.Errorf("%q: got %q %q %v", tt.txt, typ, ptxt, ok)
+		}
+	}
+	if _, _, ok := patternText("\x01VERSION\x01"); ok {
+		t.Errorf("expected VERSION to be answered")
+	}
+	pm, err := NewPatternMatcher([]Pattern{
+		{Match: "waves", Template: "text"},
+		{Match: "waves", Template: "action", Type: PatternAction},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ms := pm.Matches(PatternAction, "waves"); len(ms) != 1 || ms[0].Template != "action" {
+		t.Errorf("expected action match, got %+v", ms)
+	}
+}
@@ -0,0 +1,386 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/dcc"
+)
+
+// DCC offers files to users and accepts their offers over DCC SEND, and
+// runs admin consoles over DCC CHAT.
+type DCC struct {
+	cfg *dcc.Config
+	// local is the address of the IRC connection, advertised if the
+	// config has no ExternalIP.
+	local net.IP
+	b     *Bot
+	// pending transfers are waiting on a RESUME, ACCEPT, or reverse SEND.
+	pending map[dccKey]*dccPending
+	// recvs counts running receives by sender.
+	recvs map[string]int
+	nrecv int
+	mu    sync.Mutex
+}
+
+// dccKey identifies a transfer by its peer, port, and passive token.
+type dccKey struct {
+	nick  string
+	port  int
+	token string
+}
+
+type dccPending struct {
+	file string
+	size int64
+	// off is where a send resumes from.
+	off int64
+	// replyc gets the ACCEPT or reverse SEND the transfer waits on.
+	replyc chan *dcc.Request
+}
+
+func NewDCC(b *Bot, cfg *dcc.Config, local net.IP) *DCC {
+	return &DCC{b: b, cfg: cfg, local: local, pending: make(map[dccKey]*dccPending),
+		recvs: make(map[string]int)}
+}
+
+// startRecv reserves a receive from nick within the config's limits,
+// returning a func to release it.
+func (d *DCC) startRecv(cfg *dcc.Config, nick string) (func(), error) {
+	total, perNick := cfg.RecvLimits()
+	nick = strings.ToLower(nick)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.nrecv >= total || d.recvs[nick] >= perNick {
+		return nil, fmt.Errorf("too many receives")
+	}
+	d.nrecv++
+	d.recvs[nick]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			d.mu.Lock()
+			d.nrecv--
+			if d.recvs[nick]--; d.recvs[nick] == 0 {
+				delete(d.recvs, nick)
+			}
+			d.mu.Unlock()
+		})
+	}, nil
+}
+
+func (d *DCC) setConfig(cfg *dcc.Config) {
+	d.mu.Lock()
+	d.cfg = cfg
+	d.mu.Unlock()
+}
+
+func (d *DCC) config() *dcc.Config {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cfg
+}
+
+func (d *DCC) ctcp(nick string, r *dcc.Request) error {
+	return d.b.mc.WriteMsg(irc.Message{Command: irc.PRIVMSG, Params: []string{nick, r.CTCP()}})
+}
+
+func (d *DCC) addPending(k dccKey, p *dccPending) func() {
+	k.nick = strings.ToLower(k.nick)
+	d.mu.Lock()
+	d.pending[k] = p
+	d.mu.Unlock()
+	return func() {
+		d.mu.Lock()
+		delete(d.pending, k)
+		d.mu.Unlock()
+	}
+}
+
+func (d *DCC) lookup(nick string, r *dcc.Request) *dccPending {
+	k := dccKey{strings.ToLower(nick), r.Port, r.Token}
+	if r.Token != "" {
+		// Passive transfers are keyed by token with port 0.
+		k.port = 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.pending[k]
+}
+
+func dccToken() string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// dccOffer is a SEND or CHAT the bot offered, waiting for the peer to
+// connect or, if passive, to say where to connect.
+type dccOffer struct {
+	ln   net.Listener
+	p    *dccPending
+	done func()
+}
+
+// offer sends r to nick, listening for the connection unless passive.
+func (d *DCC) offer(cfg *dcc.Config, nick string, r *dcc.Request) (*dccOffer, error) {
+	o := &dccOffer{p: &dccPending{file: r.File, size: r.Size, replyc: make(chan *dcc.Request, 1)}}
+	if cfg.Passive {
+		r.Token = dccToken()
+	} else {
+		var err error
+		if r.IP, err = cfg.AdvertiseIP(d.local); err == nil {
+			o.ln, err = cfg.Listen()
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.Port = o.ln.Addr().(*net.TCPAddr).Port
+	}
+	o.done = d.addPending(dccKey{nick, r.Port, r.Token}, o.p)
+	// The listener queues the connection until the task accepts it.
+	if err := d.ctcp(nick, r); err != nil {
+		o.close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func (o *dccOffer) connect(ctx context.Context) (net.Conn, error) {
+	if o.ln != nil {
+		return dcc.Accept(ctx, o.ln)
+	}
+	select {
+	case r := <-o.p.replyc:
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, "tcp", r.Addr())
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (o *dccOffer) close() {
+	if o.ln != nil {
+		o.ln.Close()
+	}
+	o.done()
+}
+
+// Send offers a file from the send directory to nick.
+func (d *DCC) Send(nick, name string) error {
+	cfg := d.config()
+	if cfg == nil {
+		return fmt.Errorf("dcc not configured")
+	}
+	f, size, err := cfg.Open(name)
+	if err != nil {
+		return err
+	}
+	o, err := d.offer(cfg, nick, &dcc.Request{Cmd: "SEND", File: name, Size: size})
+	if err != nil {
+		f.Close()
+		return err
+	}
+	cmd := fmt.Sprintf("DCC SEND %s to %s", name, nick)
+	err = d.b.Tasks.RunLimited("dcc send", cmd, Limits{}, func(t *Task) error {
+		defer f.Close()
+		defer o.close()
+		t.mu.Lock()
+		t.Target, t.Progress = nick, &Progress{Size: size}
+		t.mu.Unlock()
+		ctx, cancel := context.WithTimeout(t.ctx, cfg.Timeout())
+		defer cancel()
+		conn, err := o.connect(ctx)
+		if err != nil {
+			return err
+		}
+		d.mu.Lock()
+		off := o.p.off
+		d.mu.Unlock()
+		return dcc.Send(t.ctx, conn, f, off, size, t.Progress.Set)
+	})
+	if err != nil {
+		f.Close()
+		o.close()
+	}
+	return err
+}
+
+// receive accepts an offer into the receive directory, resuming any
+// partial file and refusing files already received.
+func (d *DCC) receive(cfg *dcc.Config, from *irc.Prefix, r *dcc.Request) error {
+	release, err := d.startRecv(cfg, from.Name)
+	if err != nil {
+		return err
+	}
+	f, off, err := cfg.Create(r.File, r.Size)
+	if err != nil {
+		release()
+		return err
+	}
+	var p *dccPending
+	var done func()
+	if off > 0 {
+		p = &dccPending{file: r.File, size: r.Size, replyc: make(chan *dcc.Request, 1)}
+		done = d.addPending(dccKey{from.Name, r.Port, r.Token}, p)
+	}
+	cmd := fmt.Sprintf("DCC RECV %s from %s", r.File, from.Name)
+	err = d.b.Tasks.RunLimited("dcc recv", cmd, Limits{}, func(t *Task) error {
+		defer release()
+		defer f.Close()
+		t.mu.Lock()
+		t.From, t.Target, t.Progress = from.String(), from.Name, &Progress{Size: r.Size}
+		t.mu.Unlock()
+		t.Progress.Set(off)
+		ctx, cancel := context.WithTimeout(t.ctx, cfg.Timeout())
+		defer cancel()
+		if p != nil {
+			defer done()
+			resume := &dcc.Request{Cmd: "RESUME", File: r.File, Port: r.Port, Pos: off, Token: r.Token}
+			if err := d.ctcp(from.Name, resume); err != nil {
+				return err
+			}
+			select {
+			case a := <-p.replyc:
+				if off = a.Pos; off > r.Size {
+					return fmt.Errorf("accepted past end of file")
+				}
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if err := f.Truncate(off); err != nil {
+				return err
+			} else if _, err := f.Seek(off, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		var conn net.Conn
+		var err error
+		if r.Port != 0 {
+			var dialer net.Dialer
+			conn, err = dialer.DialContext(ctx, "tcp", r.Addr())
+		} else {
+			conn, err = d.listenReverse(ctx, cfg, from.Name, r)
+		}
+		if err != nil {
+			return err
+		}
+		if err := dcc.Receive(t.ctx, conn, f, off, r.Size, t.Progress.Set); err != nil {
+			return err
+		}
+		return cfg.Complete(r.File)
+	})
+	if err != nil {
+		f.Close()
+		release()
+		if done != nil {
+			done()
+		}
+	}
+	return err
+}
+
+// listenReverse tells the sender of a passive offer where to connect.
+func (d *DCC) listenReverse(ctx context.Context, cfg *dcc.Config, nick string, r *dcc.Request) (net.Conn, error) {
+	ip, err := cfg.AdvertiseIP(d.local)
+	if err != nil {
+		return nil, err
+	}
+	ln, err := cfg.Listen()
+	if err != nil {
+		return nil, err
+	}
+	reply := *r
+	reply.IP, reply.Port = ip, ln.Addr().(*net.TCPAddr).Port
+	if err := d.ctcp(nick, &reply); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return dcc.Accept(ctx, ln)
+}
+
+func (d *DCC) Process(msg irc.Message) error {
+	if msg.Command != irc.PRIVMSG || msg.Prefix == nil || len(msg.Params) < 2 {
+		return nil
+	}
+	txt := msg.Params[1]
+	if !strings.HasPrefix(txt, "\x01DCC ") {
+		return nil
+	}
+	cfg := d.config()
+	if cfg == nil {
+		return nil
+	}
+	r, err := dcc.Parse(txt)
+	if err != nil {
+		log.Printf("[dcc] bad request from %s (%v)", msg.Prefix, err)
+		return nil
+	}
+	nick := msg.Prefix.Name
+	p := d.lookup(nick, r)
+	switch {
+	case r.Cmd == "RESUME" && p != nil && p.file == r.File:
+		d.mu.Lock()
+		if r.Pos <= p.size {
+			p.off = r.Pos
+		}
+		accept := &dcc.Request{Cmd: "ACCEPT", File: r.File, Port: r.Port, Pos: p.off, Token: r.Token}
+		d.mu.Unlock()
+		err = d.ctcp(nick, accept)
+	case r.Cmd == "ACCEPT" && p != nil, (r.Cmd == "SEND" || r.Cmd == "CHAT") && p != nil && r.Port != 0:
+		// Accepted resume or the peer's address for a passive offer.
+		select {
+		case p.replyc <- r:
+		default:
+		}
+	case r.Cmd == "SEND" && p == nil && cfg.RecvDir != "":
+		// Strangers could otherwise have the bot connect to local services.
+		if r.Port != 0 && !r.Public() && !d.b.IsOwner(msg.Prefix) {
+			log.Printf("[dcc] refusing send from %s to %s", msg.Prefix, r.Addr())
+			return nil
+		}
+		err = d.receive(cfg, msg.Prefix, r)
+	case r.Cmd == "CHAT" && p == nil:
+		if !d.b.IsOwner(msg.Prefix) {
+			log.Printf("[dcc] refusing chat from %s", msg.Prefix)
+			return nil
+		}
+		err = d.acceptChat(cfg, msg.Prefix, r)
+	default:
+		return nil
+	}
+	if err != nil {
+		log.Printf("[dcc] %s %q from %s failed (%v)", r.Cmd, r.File, msg.Prefix, err)
+	}
+	return nil
+}
+
+// directive handles a control line from a task's output:
+//
+//	dcc send <nick> <file>
+//
+// The task must be allowed to message nick or have been started by nick.
+func (d *DCC) directive(t *Task, tgt, l string) error {
+	fs := strings.Fields(l)
+	if len(fs) != 4 || fs[0] != "dcc" || fs[1] != "send" {
+		return fmt.Errorf("bad directive %q", l)
+	}
+	nick, file := fs[2], fs[3]
+	t.mu.Lock()
+	from, _, _ := strings.Cut(t.From, "!")
+	t.mu.Unlock()
+	msg := irc.Message{Command: irc.PRIVMSG, Params: []string{nick}}
+	if !strings.EqualFold(from, nick) && !t.Allows(t.tid, msg) {
+		return fmt.Errorf("task may not send to %q", nick)
+	}
+	return d.Send(nick, file)
+}
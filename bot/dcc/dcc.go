@@ -0,0 +1,420 @@
+// Package dcc implements the DCC SEND file transfer protocol, including
+// passive (reverse) transfers and RESUME/ACCEPT.
+package dcc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultTimeout = 2 * time.Minute
+
+// Defaults bounding what strangers can send to the bot.
+const (
+	defaultMaxRecvBytes    = 64 << 20
+	defaultMaxRecvs        = 4
+	defaultMaxRecvsPerNick = 1
+)
+
+var ErrNotAllowed = errors.New("file not allowed")
+
+// partSuffix marks files still being received.
+const partSuffix = ".part"
+
+// Config controls a bot's transfers.
+type Config struct {
+	// PortMin and PortMax bound listening ports; zero lets the OS choose.
+	PortMin int `json:",omitempty"`
+	PortMax int `json:",omitempty"`
+	// ExternalIP is advertised in offers instead of the local address.
+	ExternalIP string `json:",omitempty"`
+	// SendDir is the only directory files are offered from.
+	SendDir string `json:",omitempty"`
+	// RecvDir accepts offered files; offers are ignored if empty.
+	RecvDir string `json:",omitempty"`
+	// MaxRecvBytes rejects larger offers; zero means 64MiB and negative
+	// is unlimited.
+	MaxRecvBytes int64 `json:",omitempty"`
+	// MaxRecvs and MaxRecvsPerNick bound concurrent receives in total and
+	// from one sender; zero means 4 and 1.
+	MaxRecvs        int `json:",omitempty"`
+	MaxRecvsPerNick int `json:",omitempty"`
+	// Passive offers files by reverse DCC so the receiver listens.
+	Passive bool `json:",omitempty"`
+	// TimeoutMs bounds waiting for the other side to connect or reply.
+	TimeoutMs int `json:",omitempty"`
+}
+
+func (c *Config) Timeout() time.Duration {
+	if c.TimeoutMs > 0 {
+		return time.Duration(c.TimeoutMs) * time.Millisecond
+	}
+	return defaultTimeout
+}
+
+// MaxRecv is the largest offer accepted, or negative if unlimited.
+func (c *Config) MaxRecv() int64 {
+	if c.MaxRecvBytes == 0 {
+		return defaultMaxRecvBytes
+	}
+	return c.MaxRecvBytes
+}
+
+// RecvLimits returns how many receives may run in total and per sender.
+func (c *Config) RecvLimits() (total, perNick int) {
+	total, perNick = c.MaxRecvs, c.MaxRecvsPerNick
+	if total <= 0 {
+		total = defaultMaxRecvs
+	}
+	if perNick <= 0 {
+		perNick = defaultMaxRecvsPerNick
+	}
+	return total, perNick
+}
+
+// Listen listens on a TCP port in the configured range.
+func (c *Config) Listen() (net.Listener, error) {
+	if c.PortMin <= 0 || c.PortMax < c.PortMin {
+		return net.Listen("tcp", ":0")
+	}
+	n := c.PortMax - c.PortMin + 1
+	start := rand.Intn(n)
+	var err error
+	for i := 0; i < n; i++ {
+		port := c.PortMin + (start+i)%n
+		var ln net.Listener
+		if ln, err = net.Listen("tcp", ":"+strconv.Itoa(port)); err == nil {
+			return ln, nil
+		}
+	}
+	return nil, fmt.Errorf("no free port in %d-%d (%v)", c.PortMin, c.PortMax, err)
+}
+
+// AdvertiseIP is the address put in offers, falling back to local.
+func (c *Config) AdvertiseIP(local net.IP) (net.IP, error) {
+	ip := local
+	if c.ExternalIP != "" {
+		if ip = net.ParseIP(c.ExternalIP); ip == nil {
+			return nil, fmt.Errorf("bad ExternalIP %q", c.ExternalIP)
+		}
+	}
+	if ip == nil || ip.IsUnspecified() {
+		return nil, errors.New("no address to advertise; set ExternalIP")
+	}
+	return ip, nil
+}
+
+// Open opens a file from SendDir for sending.
+func (c *Config) Open(name string) (*os.File, int64, error) {
+	if c.SendDir == "" || !safeName(name) {
+		return nil, 0, ErrNotAllowed
+	}
+	f, err := os.Open(filepath.Join(c.SendDir, name))
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, 0, ErrNotAllowed
+	}
+	return f, fi.Size(), nil
+}
+
+// Create opens a partial file in RecvDir for receiving, returning the
+// size of the part to resume from. Received files are never replaced.
+func (c *Config) Create(name string, size int64) (*os.File, int64, error) {
+	if c.RecvDir == "" || !safeName(name) || strings.HasSuffix(name, partSuffix) {
+		return nil, 0, ErrNotAllowed
+	} else if m := c.MaxRecv(); m >= 0 && size > m {
+		return nil, 0, fmt.Errorf("%q is over %d bytes", name, m)
+	}
+	if err := os.MkdirAll(c.RecvDir, 0700); err != nil {
+		return nil, 0, err
+	}
+	if _, err := os.Lstat(filepath.Join(c.RecvDir, name)); err == nil {
+		return nil, 0, fmt.Errorf("%q already received", name)
+	}
+	f, err := os.OpenFile(filepath.Join(c.RecvDir, name+partSuffix), os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, err
+	}
+	off, err := f.Seek(0, io.SeekEnd)
+	if err != nil || off >= size {
+		// Start over on a part that cannot be resumed.
+		if off, err = 0, f.Truncate(0); err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, off, nil
+}
+
+// Complete moves a fully received file into place, failing if a file of
+// the same name was received meanwhile.
+func (c *Config) Complete(name string) error {
+	part := filepath.Join(c.RecvDir, name+partSuffix)
+	if err := os.Link(part, filepath.Join(c.RecvDir, name)); err != nil {
+		return err
+	}
+	return os.Remove(part)
+}
+
+func safeName(name string) bool {
+	return name != "" && name == filepath.Base(name) && !strings.HasPrefix(name, ".")
+}
+
+// Request is a DCC SEND, RESUME, ACCEPT, or CHAT CTCP message.
+type Request struct {
+	Cmd string
+	// File is "chat" for CHAT.
+	File string
+	// IP is only set for SEND and CHAT.
+	IP   net.IP
+	Port int
+	// Size is the file size for SEND; Pos is the offset for RESUME and ACCEPT.
+	Size int64
+	Pos  int64
+	// Token identifies passive transfers, which have port 0.
+	Token string
+}
+
+// Parse parses a CTCP DCC message, with or without its \x01 delimiters.
+func Parse(s string) (*Request, error) {
+	s = strings.Trim(s, "\x01")
+	rest, ok := strings.CutPrefix(s, "DCC ")
+	if !ok {
+		return nil, fmt.Errorf("not a DCC message")
+	}
+	cmd, rest, _ := strings.Cut(rest, " ")
+	r := &Request{Cmd: cmd}
+	if strings.HasPrefix(rest, `"`) {
+		end := strings.Index(rest[1:], `"`)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated file name")
+		}
+		r.File, rest = rest[1:end+1], rest[end+2:]
+	} else {
+		r.File, rest, _ = strings.Cut(rest, " ")
+	}
+	fs := strings.Fields(rest)
+	var err error
+	switch cmd {
+	case "SEND":
+		if len(fs) < 3 {
+			return nil, fmt.Errorf("short DCC SEND")
+		}
+		if r.IP, err = parseIP(fs[0]); err != nil {
+			return nil, err
+		}
+		if r.Port, err = strconv.Atoi(fs[1]); err != nil {
+			return nil, err
+		}
+		if r.Size, err = strconv.ParseInt(fs[2], 10, 64); err != nil {
+			return nil, err
+		}
+		fs = fs[3:]
+	case "CHAT":
+		if len(fs) < 2 {
+			return nil, fmt.Errorf("short DCC CHAT")
+		}
+		if r.IP, err = parseIP(fs[0]); err != nil {
+			return nil, err
+		}
+		if r.Port, err = strconv.Atoi(fs[1]); err != nil {
+			return nil, err
+		}
+		fs = fs[2:]
+	case "RESUME", "ACCEPT":
+		if len(fs) < 2 {
+			return nil, fmt.Errorf("short DCC %s", cmd)
+		}
+		if r.Port, err = strconv.Atoi(fs[0]); err != nil {
+			return nil, err
+		}
+		if r.Pos, err = strconv.ParseInt(fs[1], 10, 64); err != nil {
+			return nil, err
+		}
+		fs = fs[2:]
+	default:
+		return nil, fmt.Errorf("unsupported DCC %s", cmd)
+	}
+	if r.Port < 0 || r.Port > 65535 || r.Size < 0 || r.Pos < 0 {
+		return nil, fmt.Errorf("bad DCC %s values", cmd)
+	}
+	if len(fs) > 0 {
+		r.Token = fs[0]
+	}
+	return r, nil
+}
+
+func parseIP(s string) (net.IP, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("bad DCC address %q", s)
+}
+
+func formatIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+	return ip.String()
+}
+
+// CTCP formats the request as a CTCP message.
+func (r *Request) CTCP() string {
+	f := r.File
+	if strings.Contains(f, " ") {
+		f = `"` + f + `"`
+	}
+	ip := "0"
+	if r.IP != nil {
+		ip = formatIP(r.IP)
+	}
+	var s string
+	switch r.Cmd {
+	case "SEND":
+		s = fmt.Sprintf("DCC SEND %s %s %d %d", f, ip, r.Port, r.Size)
+	case "CHAT":
+		s = fmt.Sprintf("DCC CHAT %s %s %d", f, ip, r.Port)
+	default:
+		s = fmt.Sprintf("DCC %s %s %d %d", r.Cmd, f, r.Port, r.Pos)
+	}
+	if r.Token != "" {
+		s += " " + r.Token
+	}
+	return "\x01" + s + "\x01"
+}
+
+// Addr is the address to dial for a SEND or CHAT.
+func (r *Request) Addr() string {
+	return net.JoinHostPort(r.IP.String(), strconv.Itoa(r.Port))
+}
+
+// Public reports whether the address to dial is a public unicast address,
+// so dialing it cannot reach the bot's own host or network.
+func (r *Request) Public() bool {
+	ip := r.IP
+	return ip.IsGlobalUnicast() && !ip.IsPrivate() && !ip.IsLoopback()
+}
+
+// Accept accepts one connection from ln, closing ln.
+func Accept(ctx context.Context, ln net.Listener) (net.Conn, error) {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+	conn, err := ln.Accept()
+	ln.Close()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
+
+// Send writes f from off to size over conn, then waits for the receiver to
+// acknowledge everything. Progress is called with the position.
+func Send(ctx context.Context, conn net.Conn, f io.ReaderAt, off, size int64, progress func(int64)) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+	// Acknowledgements are only drained; TCP already ensures delivery.
+	// ackc holds the latest one, replacing any not yet read.
+	ackc := make(chan uint32, 1)
+	go func() {
+		defer close(ackc)
+		var b [4]byte
+		for {
+			if _, err := io.ReadFull(conn, b[:]); err != nil {
+				return
+			}
+			select {
+			case <-ackc:
+			default:
+			}
+			ackc <- binary.BigEndian.Uint32(b[:])
+		}
+	}()
+	buf := make([]byte, 32*1024)
+	for pos := off; pos < size; {
+		n, err := f.ReadAt(buf[:min(int64(len(buf)), size-pos)], pos)
+		if n > 0 {
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return ctxErr(ctx, werr)
+			}
+			pos += int64(n)
+			progress(pos)
+		}
+		if err != nil && !(err == io.EOF && pos == size) {
+			return err
+		}
+	}
+	// Wait for the final ack, or the receiver hanging up.
+	for want := uint32(size); ; {
+		select {
+		case ack, ok := <-ackc:
+			if !ok || ack == want {
+				return nil
+			}
+		case <-time.After(defaultTimeout):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Receive reads from conn into w from off to size, acknowledging the
+// position after each read.
+func Receive(ctx context.Context, conn net.Conn, w io.Writer, off, size int64, progress func(int64)) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	defer conn.Close()
+	buf := make([]byte, 32*1024)
+	var ack [4]byte
+	for pos := off; pos < size; {
+		n, err := conn.Read(buf[:min(int64(len(buf)), size-pos)])
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+			pos += int64(n)
+			progress(pos)
+			binary.BigEndian.PutUint32(ack[:], uint32(pos))
+			if _, werr := conn.Write(ack[:]); werr != nil {
+				return ctxErr(ctx, werr)
+			}
+		}
+		if err != nil {
+			if err == io.EOF && pos < size {
+				return io.ErrUnexpectedEOF
+			}
+			return ctxErr(ctx, err)
+		}
+	}
+	return nil
+}
+
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
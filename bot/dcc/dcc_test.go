@@ -0,0 +1,159 @@
+package dcc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	r, err := Parse("\x01DCC SEND test.mp3 2130706433 39343 4285600 123\x01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cmd != "SEND" || r.File != "test.mp3" || r.IP.String() != "127.0.0.1" ||
+		r.Port != 39343 || r.Size != 4285600 || r.Token != "123" {
+		t.Fatalf("bad parse %+v", r)
+	}
+	if s := r.CTCP(); s != "\x01DCC SEND test.mp3 2130706433 39343 4285600 123\x01" {
+		t.Fatalf("bad format %q", s)
+	}
+	r, err = Parse(`DCC RESUME "a b.zip" 0 100 7`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.File != "a b.zip" || r.Pos != 100 || r.Token != "7" {
+		t.Fatalf("bad parse %+v", r)
+	}
+	r, err = Parse("\x01DCC CHAT chat 2130706433 4000\x01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Cmd != "CHAT" || r.Port != 4000 || r.CTCP() != "\x01DCC CHAT chat 2130706433 4000\x01" {
+		t.Fatalf("bad chat %+v", r)
+	}
+	for _, s := range []string{"DCC SEND x 1 2", "DCC CHAT chat 1", "DCC FOO x 1 2", "DCC SEND x 1 99999 3", "PING"} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected error on %q", s)
+		}
+	}
+}
+
+func TestPublic(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"203.0.113.7", true},
+		{"2001:db8::1", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"192.168.0.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"224.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if r := (&Request{IP: net.ParseIP(tt.ip)}); r.Public() != tt.public {
+			t.Errorf("%s: expected public=%v", tt.ip, tt.public)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	cfg := &Config{SendDir: filepath.Join(dir, "send"), RecvDir: filepath.Join(dir, "recv")}
+	os.MkdirAll(cfg.SendDir, 0700)
+	os.MkdirAll(cfg.RecvDir, 0700)
+	os.WriteFile(filepath.Join(cfg.SendDir, "f"), data, 0600)
+	os.WriteFile(filepath.Join(cfg.RecvDir, "f.part"), data[:1234], 0600)
+	if _, _, err := cfg.Open("../send/f"); err != ErrNotAllowed {
+		t.Fatalf("expected ErrNotAllowed, got %v", err)
+	}
+
+	src, size, err := cfg.Open("f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, off, err := cfg.Create("f", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if off != 1234 {
+		t.Fatalf("expected resume at 1234, got %d", off)
+	}
+	c1, c2 := net.Pipe()
+	errc := make(chan error, 1)
+	go func() { errc <- Send(context.Background(), c1, src, off, size, func(int64) {}) }()
+	var last int64
+	if err := Receive(context.Background(), c2, dst, off, size, func(n int64) { last = n }); err != nil {
+		t.Fatal(err)
+	}
+	dst.Close()
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if last != size {
+		t.Fatalf("expected progress %d, got %d", size, last)
+	}
+	if err := cfg.Complete("f"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(cfg.RecvDir, "f")); !bytes.Equal(b, data) {
+		t.Fatalf("received file differs")
+	}
+	if _, err := os.Stat(filepath.Join(cfg.RecvDir, "f.part")); !os.IsNotExist(err) {
+		t.Errorf("expected part to be removed, got %v", err)
+	}
+	if _, _, err := cfg.Create("f", 10); err == nil {
+		t.Errorf("expected received file to be kept")
+	}
+	if _, _, err := cfg.Create("g.part", 10); err != ErrNotAllowed {
+		t.Errorf("expected ErrNotAllowed for part name, got %v", err)
+	}
+	if _, _, err := cfg.Create("big", defaultMaxRecvBytes+1); err == nil {
+		t.Errorf("expected offer over the default size limit to be refused")
+	}
+}
+
+func TestSendFinalAck(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 10000)
+	size := int64(len(data))
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	errc := make(chan error, 1)
+	go func() { errc <- Send(context.Background(), c1, bytes.NewReader(data), 0, size, func(int64) {}) }()
+	// Ack twice while the sender is still writing, then hold the
+	// connection open; the sender must not wait on the stale first ack.
+	if _, err := io.ReadFull(c2, make([]byte, 10)); err != nil {
+		t.Fatal(err)
+	}
+	for _, ack := range []uint32{10, uint32(size)} {
+		if err := binary.Write(c2, binary.BigEndian, ack); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Let both acks reach the sender before it finishes writing.
+	time.Sleep(10 * time.Millisecond)
+	if _, err := io.ReadFull(c2, make([]byte, size-10)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("sender missed the final ack")
+	}
+}
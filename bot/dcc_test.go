@@ -0,0 +1,33 @@
+package bot
+
+import (
+	"testing"
+
+	"github.com/chzchzchz/sitbot/bot/dcc"
+)
+
+func TestDCCRecvLimits(t *testing.T) {
+	d := NewDCC(nil, nil, nil)
+	cfg := &dcc.Config{MaxRecvs: 2}
+	release, err := d.startRecv(cfg, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.startRecv(cfg, "ALICE"); err == nil {
+		t.Errorf("alice started a second receive")
+	}
+	if _, err := d.startRecv(cfg, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.startRecv(cfg, "carol"); err == nil {
+		t.Errorf("carol went over the total")
+	}
+	release()
+	release()
+	if _, err := d.startRecv(cfg, "carol"); err != nil {
+		t.Errorf("carol refused after a release (%v)", err)
+	}
+	if _, err := d.startRecv(cfg, "dave"); err == nil {
+		t.Errorf("a double release freed two receives")
+	}
+}
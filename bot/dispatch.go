@@ -4,6 +4,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"gopkg.in/sorcix/irc.v2"
 )
@@ -11,13 +12,67 @@ import (
 type Dispatcher struct {
 	*Tasks
 	*Profile
-	pm    *PatternMatcher
-	pmraw *PatternMatcher
-	mu    sync.RWMutex
+	pm       *PatternMatcher
+	pmraw    *PatternMatcher
+	sessions map[string]*session
+	// hits counts matches per pattern, by Match so counts follow patterns
+	// across updates.
+	hits map[patternKey]uint64
+	// control handles owner commands before patterns, reporting whether
+	// the message was one.
+	control func(msg irc.Message) bool
+	mu      sync.RWMutex
+}
+
+type patternKey struct {
+	raw   bool
+	match string
+}
+
+// sessionLines is how many lines a session queues for its script before
+// dropping them.
+const sessionLines = 64
+
+// session queues a sender's messages for an interactive task's stdin. It
+// is opened before the task starts, so early lines wait for the script.
+type session struct {
+	cmd     string
+	linec   chan string
+	closed  bool
+	timeout time.Duration
+	timer   *time.Timer
+	mu      sync.Mutex
+}
+
+// send queues l without blocking, reporting false if the queue is full.
+func (s *session) send(l string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return true
+	}
+	select {
+	case s.linec <- l:
+		return true
+	default:
+		return false
+	}
+}
+
+// close ends the script's stdin once it has read the queued lines.
+func (s *session) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.linec)
+	}
 }
 
 func NewDispatcher(p *Profile, t *Tasks) *Dispatcher {
-	return &Dispatcher{Tasks: t, Profile: p}
+	return &Dispatcher{Tasks: t, Profile: p,
+		sessions: make(map[string]*session),
+		hits:     make(map[patternKey]uint64)}
 }
 
 func (d *Dispatcher) Env() []string {
@@ -39,48 +94,169 @@ func (d *Dispatcher) Update(pats, rawpats []Pattern) error {
 	return nil
 }
 
-func (d *Dispatcher) processPrivMsg(t *Task, msg irc.Message) error {
+func sessionKey(msg irc.Message) string {
+	return msg.Prefix.Name + " " + msg.Params[0]
+}
+
+func (d *Dispatcher) openSession(key, cmd string, timeout time.Duration) *session {
+	s := &session{cmd: cmd, linec: make(chan string, sessionLines), timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() { d.dropSession(key, s) })
+	d.mu.Lock()
+	if old := d.sessions[key]; old != nil {
+		old.timer.Stop()
+		old.close()
+	}
+	d.sessions[key] = s
+	d.mu.Unlock()
+	return s
+}
+
+func (d *Dispatcher) dropSession(key string, s *session) {
+	s.timer.Stop()
+	s.close()
+	d.mu.Lock()
+	if d.sessions[key] == s {
+		delete(d.sessions, key)
+	}
+	d.mu.Unlock()
+}
+
+// feedSession writes the message to the sender's session, if any.
+func (d *Dispatcher) feedSession(msg irc.Message) bool {
+	d.mu.RLock()
+	s := d.sessions[sessionKey(msg)]
+	d.mu.RUnlock()
+	if s == nil {
+		return false
+	}
+	s.timer.Reset(s.timeout)
+	if !s.send(msg.Params[1]) {
+		log.Printf("[session] dropped line for %q", s.cmd)
+	}
+	return true
+}
+
+// privMsgCmd returns the command, output target, and environment for a
+// task matched by a PRIVMSG.
+func (d *Dispatcher) privMsgCmd(cmdtxt string, msg irc.Message) (string, string, []string) {
 	sender, tgt := msg.Prefix.Name, msg.Params[0]
 	outtgt := tgt
 	if tgt[0] != '#' {
 		outtgt = sender
 	}
-	cmdtxt := strings.Replace(t.Command, "%s", sender, -1)
 	env := append(d.Env(),
 		"SITBOT_FROM="+sender,
 		"SITBOT_CHAN="+tgt,
 		"SITBOT_MSG="+msg.Params[1])
+	if typ, _, ok := ctcpSplit(msg.Params[1]); ok {
+		env = append(env, "SITBOT_CTCP="+typ)
+	}
+	return strings.Replace(cmdtxt, "%s", sender, -1), outtgt, env
+}
+
+func (d *Dispatcher) processPrivMsg(t *Task, msg irc.Message) error {
+	cmdtxt, outtgt, env := d.privMsgCmd(t.Command, msg)
 	return t.PipeCmd(cmdtxt, outtgt, env)
 }
 
-func (d *Dispatcher) run(name, cmdtxt string, pm **PatternMatcher, f TaskFunc) {
+// run runs the task for the first pattern matching cmdtxt. If the pattern
+// has a session, it is opened under skey and fed to the task's stdin.
+func (d *Dispatcher) run(name, typ, cmdtxt, from, skey string, pm **PatternMatcher, f func(*Task, *Pattern) error) {
 	d.mu.RLock()
 	p := *pm
 	d.mu.RUnlock()
 	if p == nil {
 		return
 	}
-	taskCmd := p.Apply(cmdtxt)
+	i, taskCmd := p.find(typ, cmdtxt)
 	if taskCmd == "" {
 		return
 	}
+	pat := &p.pats[i]
+	d.mu.Lock()
+	d.hits[patternKey{pm == &d.pmraw, pat.Match}]++
+	d.mu.Unlock()
 	log.Printf("[task] %q matched to %q", cmdtxt, taskCmd)
-	d.Tasks.Run(name, taskCmd, f)
+	l := d.Limits.Merge(pat.Limits)
+	// Name the rule by its Match so it still reads right after a reload.
+	rule := "Patterns:" + pat.Match
+	if pm == &d.pmraw {
+		rule = "PatternsRaw:" + pat.Match
+	}
+	var s *session
+	if skey != "" && pat.SessionMs > 0 {
+		s = d.openSession(skey, taskCmd, time.Duration(pat.SessionMs)*time.Millisecond)
+	}
+	tf := func(t *Task) error {
+		t.mu.Lock()
+		t.targets, t.killAny, t.From, t.Rule = pat.Targets, pat.KillTasks, from, rule
+		t.mu.Unlock()
+		if s != nil {
+			t.stdinc = s.linec
+		}
+		return f(t, pat)
+	}
+	t, err := d.Tasks.run(name, taskCmd, l, false, tf)
+	if err != nil {
+		log.Printf("[task] could not run %q (%v)", taskCmd, err)
+	}
+	if s == nil {
+		return
+	} else if err != nil {
+		d.dropSession(skey, s)
+		return
+	}
+	go func() {
+		<-t.donec
+		d.dropSession(skey, s)
+	}()
+}
+
+// RunScript runs cmdtxt as a task sending its output to tgt.
+func (d *Dispatcher) RunScript(name, cmdtxt, tgt string, l *Limits, env ...string) error {
+	return d.runScript(name, cmdtxt, tgt, []string{tgt}, l, env...)
+}
+
+// runScript is RunScript with the targets the task's token may write to.
+func (d *Dispatcher) runScript(name, cmdtxt, tgt string, targets []string, l *Limits, env ...string) error {
+	return d.Tasks.RunLimited(name, cmdtxt, d.Limits.Merge(l), func(t *Task) error {
+		t.mu.Lock()
+		t.targets, t.Rule = targets, name
+		t.mu.Unlock()
+		env := append(append(d.Env(), "SITBOT_CHAN="+tgt), env...)
+		return t.PipeCmd(t.Command, tgt, env)
+	})
 }
 
 func (d *Dispatcher) Process(msg irc.Message) error {
+	from := ""
+	if msg.Prefix != nil {
+		from = msg.Prefix.String()
+	}
 	if msg.Command == irc.PRIVMSG {
-		if msg.Prefix != nil && len(msg.Params) > 0 {
-			tf := func(t *Task) error { return d.processPrivMsg(t, msg) }
-			d.run(msg.Params[1], msg.Params[1], &d.pm, tf)
+		if msg.Prefix != nil && len(msg.Params) > 1 && !d.isControl(msg) && !d.feedSession(msg) {
+			if typ, txt, ok := patternText(msg.Params[1]); ok {
+				tf := func(t *Task, _ *Pattern) error { return d.processPrivMsg(t, msg) }
+				d.run(txt, typ, txt, from, sessionKey(msg), &d.pm, tf)
+			}
 		}
 	}
-	msgcmd := msg.Command + " " + strings.Join(msg.Params, " ")
-	if msg.Prefix != nil {
-		msgcmd = msg.Prefix.String() + " " + msgcmd
-	}
-	d.run(msgcmd, msgcmd, &d.pmraw, func(t *Task) error {
+	msgcmd := rawLine(msg)
+	d.run(msgcmd, "", msgcmd, from, "", &d.pmraw, func(t *Task, _ *Pattern) error {
 		return t.PipeCmd(t.Command, d.Nick, d.Env())
 	})
 	return nil
 }
+
+func (d *Dispatcher) isControl(msg irc.Message) bool {
+	return d.control != nil && d.control(msg)
+}
+
+// rawLine is the text of a message matched by PatternsRaw.
+func rawLine(msg irc.Message) string {
+	l := msg.Command + " " + strings.Join(msg.Params, " ")
+	if msg.Prefix != nil {
+		l = msg.Prefix.String() + " " + l
+	}
+	return l
+}
@@ -0,0 +1,219 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// maxMsgBytes is the IRC line limit including the trailing CRLF.
+const maxMsgBytes = 512
+
+// Worst case user and host lengths until the bot's prefix is learned.
+const maxUserLen, maxHostLen = 10, 63
+
+// Formatter splits script output into lines that fit in a single message
+// without breaking multibyte characters or mIRC formatting.
+type Formatter struct {
+	// cs is the output charset; encoders are stateful, so each Split
+	// makes its own.
+	cs   encoding.Encoding
+	self *irc.Prefix
+	mu   sync.RWMutex
+}
+
+// NewFormatter makes a Formatter that transcodes output to charset, if given.
+func NewFormatter(charset string) (*Formatter, error) {
+	f := &Formatter{}
+	if charset != "" {
+		e, err := htmlindex.Get(charset)
+		if err != nil {
+			return nil, err
+		}
+		f.cs = e
+	}
+	return f, nil
+}
+
+// SetPrefix sets the bot's prefix as seen by other clients.
+func (f *Formatter) SetPrefix(p *irc.Prefix) {
+	f.mu.Lock()
+	f.self = p
+	f.mu.Unlock()
+}
+
+// Budget is the number of text bytes available to a cmd message to tgt.
+func (f *Formatter) Budget(cmd, tgt string) int {
+	f.mu.RLock()
+	pfx := ""
+	if f.self != nil {
+		pfx = f.self.Name
+		if f.self.User != "" && f.self.Host != "" {
+			pfx = f.self.String()
+		} else {
+			pfx += "!" + strings.Repeat("x", maxUserLen) + "@" + strings.Repeat("x", maxHostLen)
+		}
+	}
+	f.mu.RUnlock()
+	return maxMsgBytes - len(":"+pfx+" "+cmd+" "+tgt+" :\r\n")
+}
+
+func encode(enc *encoding.Encoder, s string) string {
+	if enc == nil {
+		return s
+	}
+	out, err := enc.String(s)
+	if err != nil {
+		return s
+	}
+	return out
+}
+
+// Split breaks l into encoded lines of at most n bytes. Lines are split
+// after spaces if possible, otherwise between user-perceived characters,
+// and continuation lines restore any active formatting.
+func (f *Formatter) Split(l string, n int) (ret []string) {
+	l = strings.TrimRight(l, "\r\n")
+	if l == "" {
+		return nil
+	}
+	var enc *encoding.Encoder
+	if f.cs != nil {
+		enc = encoding.ReplaceUnsupported(f.cs.NewEncoder())
+	}
+	var st ircStyle
+	var line strings.Builder
+	// lastSpace is the length of line after its last space and the style
+	// at that point; after a split, the text beyond it is carried over.
+	lastSpace, spaceSt := -1, st
+	// start is the length of the codes restored at the start of line.
+	start, gs := 0, -1
+	for len(l) > 0 {
+		var u string
+		ctrl := ctrlLen(l)
+		if ctrl > 0 {
+			u, l, gs = l[:ctrl], l[ctrl:], -1
+		} else {
+			var c, rest string
+			c, rest, _, gs = uniseg.FirstGraphemeClusterInString(l, gs)
+			if u = encode(enc, c); len(u) > n {
+				// Break clusters too long for any line between runes.
+				_, i := utf8.DecodeRuneInString(l)
+				c, rest, gs = l[:i], l[i:], -1
+				u = encode(enc, c)
+			}
+			l = rest
+		}
+		if line.Len()+len(u) > n && line.Len() > start {
+			cur := line.String()
+			carry, carrySt := "", st
+			if lastSpace > 0 && len(spaceSt.codes())+len(cur)-lastSpace+len(u) <= n {
+				cur, carry, carrySt = strings.TrimRight(cur[:lastSpace], " "), cur[lastSpace:], spaceSt
+			}
+			ret = append(ret, cur)
+			line.Reset()
+			// Drop the formatting rather than overflow a tiny budget.
+			if codes := carrySt.codes(); len(codes)+len(carry)+len(u) <= n {
+				line.WriteString(codes)
+			}
+			start = line.Len()
+			line.WriteString(carry)
+			lastSpace = -1
+		}
+		line.WriteString(u)
+		if ctrl > 0 {
+			st.apply(u)
+		} else if u == " " {
+			lastSpace, spaceSt = line.Len(), st
+		}
+	}
+	if line.Len() > 0 {
+		ret = append(ret, line.String())
+	}
+	return ret
+}
+
+// ircStyle is the mIRC formatting state at some point in a line.
+type ircStyle struct {
+	bold, italic, underline, reverse, strike, mono bool
+	fg, bg                                         string
+}
+
+func (st *ircStyle) apply(code string) {
+	switch code[0] {
+	case '\x02':
+		st.bold = !st.bold
+	case '\x1d':
+		st.italic = !st.italic
+	case '\x1f':
+		st.underline = !st.underline
+	case '\x16':
+		st.reverse = !st.reverse
+	case '\x1e':
+		st.strike = !st.strike
+	case '\x11':
+		st.mono = !st.mono
+	case '\x0f':
+		*st = ircStyle{}
+	case '\x03':
+		fg, bg, _ := strings.Cut(code[1:], ",")
+		if fg == "" {
+			st.fg, st.bg = "", ""
+			break
+		}
+		st.fg = fg
+		if bg != "" {
+			st.bg = bg
+		}
+	}
+}
+
+func (st ircStyle) codes() string {
+	var b strings.Builder
+	for _, v := range []struct {
+		on bool
+		c  string
+	}{
+		{st.bold, "\x02"}, {st.italic, "\x1d"}, {st.underline, "\x1f"},
+		{st.reverse, "\x16"}, {st.strike, "\x1e"}, {st.mono, "\x11"},
+	} {
+		if v.on {
+			b.WriteString(v.c)
+		}
+	}
+	if st.fg != "" {
+		b.WriteString("\x03" + st.fg)
+		if st.bg != "" {
+			b.WriteString("," + st.bg)
+		}
+	}
+	return b.String()
+}
+
+// ctrlLen is the length of the formatting code at the start of s, if any.
+func ctrlLen(s string) int {
+	switch s[0] {
+	case '\x02', '\x1d', '\x1f', '\x16', '\x1e', '\x11', '\x0f':
+		return 1
+	case '\x03':
+	default:
+		return 0
+	}
+	i := 1 + digits(s[1:])
+	if i > 1 && i+1 < len(s) && s[i] == ',' && digits(s[i+1:]) > 0 {
+		i += 1 + digits(s[i+1:])
+	}
+	return i
+}
+
+func digits(s string) (n int) {
+	for n < 2 && n < len(s) && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
@@ -0,0 +1,110 @@
+package bot
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestSplitUTF8(t *testing.T) {
+	f, _ := NewFormatter("")
+	ls := f.Split(strings.Repeat("é", 10), 5)
+	if len(ls) != 5 {
+		t.Fatalf("expected 5 lines, got %q", ls)
+	}
+	for _, l := range ls {
+		if !utf8.ValidString(l) {
+			t.Errorf("invalid utf8 %q", l)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	f, _ := NewFormatter("")
+	ls := f.Split("hello there world\n", 12)
+	if len(ls) != 2 || ls[0] != "hello there" || ls[1] != "world" {
+		t.Errorf("bad split %q", ls)
+	}
+}
+
+func TestSplitColors(t *testing.T) {
+	f, _ := NewFormatter("")
+	ls := f.Split("\x02\x034,5abcdef", 8)
+	if len(ls) != 2 || ls[0] != "\x02\x034,5abc" || ls[1] != "\x02\x034,5def" {
+		t.Errorf("bad split %q", ls)
+	}
+}
+
+func TestSplitCharset(t *testing.T) {
+	f, err := NewFormatter("iso-8859-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ls := f.Split("ééé", 2)
+	if len(ls) != 2 || ls[0] != "\xe9\xe9" || ls[1] != "\xe9" {
+		t.Errorf("bad split %q", ls)
+	}
+}
+
+func TestSplitCharsetConcurrent(t *testing.T) {
+	// ISO-2022-JP's encoder keeps the shift state between calls.
+	f, _ := NewFormatter("iso-2022-jp")
+	want := f.Split("日本", 100)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if ls := f.Split("日本", 100); len(ls) != 1 || ls[0] != want[0] {
+					t.Errorf("bad split %q", ls)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestSplitBudget(t *testing.T) {
+	f, _ := NewFormatter("")
+	l := "\x02a bcdefg😀\x0f \x0312,04bold red\x0f plain \x1dslanted words that go on\x1d and \x0303green\x03 text"
+	for n := 8; n < 40; n++ {
+		for _, s := range f.Split(l, n) {
+			if len(s) > n {
+				t.Errorf("%d: %q is over budget", n, s)
+			}
+		}
+	}
+}
+
+func TestSplitGraphemes(t *testing.T) {
+	f, _ := NewFormatter("")
+	family, flags := "👨‍👩‍👧", "🇯🇵🇺🇸"
+	for _, tt := range []struct {
+		l    string
+		n    int
+		want []string
+	}{
+		{"ab" + family, 19, []string{"ab", family}},
+		{flags + flags, 12, []string{"🇯🇵", "🇺🇸", "🇯🇵", "🇺🇸"}},
+		{"éé", 4, []string{"é", "é"}},
+	} {
+		if ls := f.Split(tt.l, tt.n); strings.Join(ls, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%q at %d: got %q", tt.l, tt.n, ls)
+		}
+	}
+}
+
+func TestBudgetNickChange(t *testing.T) {
+	f, _ := NewFormatter("")
+	l := &Login{tasks: &Tasks{fmtr: f}, nick: "bot"}
+	f.SetPrefix(&irc.Prefix{Name: "bot", User: "u", Host: "h"})
+	l.Process(irc.Message{Prefix: &irc.Prefix{Name: "bot", User: "u", Host: "h"}, Command: irc.NICK, Params: []string{"longerbot"}})
+	if b, want := f.Budget(irc.PRIVMSG, "#c"), maxMsgBytes-len(":longerbot!u@h PRIVMSG #c :\r\n"); b != want {
+		t.Errorf("expected budget %d, got %d", want, b)
+	}
+}
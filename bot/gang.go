@@ -3,15 +3,67 @@ package bot
 import (
 	"context"
 	"fmt"
+	"log"
 	"sync"
+	"time"
 )
 
+// failedLaunchKeep is how long a failed launch stays listed.
+var failedLaunchKeep = 10 * time.Minute
+
 type Gang struct {
 	Bots map[string]*Bot
-	mu   sync.RWMutex
+	// Launches holds bots still connecting or that recently failed to
+	// connect.
+	Launches map[string]*Launch
+	// connects counts each bot's successful connections.
+	connects map[string]uint64
+	// AuditDir keeps the bots' task audit logs, if set.
+	AuditDir string
+	// RecordDir keeps recordings of the bots' traffic, if set.
+	RecordDir string
+	// Reload reloads the profiles from their source, if set.
+	Reload func() error `json:"-"`
+	// Bouncer starts a bouncer for a bot listening on addr, if set.
+	Bouncer func(b *Bot, addr string) error `json:"-"`
+	// Errors holds configuration problems keyed by their source.
+	Errors map[string]string
+	relays *relays
+	mu     sync.RWMutex
 }
 
-func NewGang() *Gang { return &Gang{Bots: make(map[string]*Bot)} }
+func NewGang() *Gang {
+	return &Gang{
+		Bots:     make(map[string]*Bot),
+		Launches: make(map[string]*Launch),
+		connects: make(map[string]uint64),
+		Errors:   make(map[string]string),
+		relays:   newRelays(),
+	}
+}
+
+// Launch is a bot connecting in the background.
+type Launch struct {
+	Id    string
+	Start Time
+	// Status is "dialing" or "registering" until the bot comes online,
+	// or "failed" with the error in Err.
+	Status string
+	Err    string `json:",omitempty"`
+	cancel context.CancelFunc
+	donec  chan struct{}
+}
+
+// SetError records or, if err is nil, clears a configuration error.
+func (g *Gang) SetError(src string, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err == nil {
+		delete(g.Errors, src)
+	} else {
+		g.Errors[src] = err.Error()
+	}
+}
 
 func (g *Gang) LockBots() {
 	g.mu.RLock()
@@ -27,35 +79,148 @@ func (g *Gang) UnlockBots() {
 	g.mu.RUnlock()
 }
 
+// Post updates a running bot or launches a new one in the background; the
+// new bot is added to Bots once it connects.
 func (g *Gang) Post(p Profile) error {
 	if bot := g.Lookup(p.Id); bot != nil {
-		return bot.Update(p)
+		if err := bot.Update(p); err != nil {
+			return err
+		}
+		g.relink()
+		return nil
 	}
-	bot, err := NewBot(context.TODO(), p)
-	if err != nil {
-		return err
+	var audit *Audit
+	if g.AuditDir != "" {
+		var err error
+		if audit, err = OpenAudit(g.AuditDir, p.Id); err != nil {
+			return err
+		}
 	}
+	ctx, cancel := context.WithCancel(context.Background())
+	l := &Launch{Id: p.Id, Start: Time(time.Now()), Status: "dialing",
+		cancel: cancel, donec: make(chan struct{})}
 	g.mu.Lock()
-	ob := g.Bots[p.Id]
-	g.Bots[p.Id] = bot
+	ol := g.Launches[p.Id]
+	g.Launches[p.Id] = l
 	g.mu.Unlock()
-	if ob != nil {
-		ob.Close()
+	if ol != nil {
+		ol.cancel()
 	}
+	go func() {
+		defer close(l.donec)
+		bot, err := newBot(ctx, p, func(status string) {
+			g.mu.Lock()
+			l.Status = status
+			g.mu.Unlock()
+		}, audit, g.RecordDir)
+		g.mu.Lock()
+		if g.Launches[p.Id] != l {
+			// Deleted or replaced while connecting.
+			g.mu.Unlock()
+			if err == nil {
+				bot.Close()
+			}
+			cancel()
+			return
+		}
+		if err != nil {
+			l.Status, l.Err = "failed", err.Error()
+			g.mu.Unlock()
+			log.Printf("[gang] failed to launch %s (%v)", p.Id, err)
+			cancel()
+			time.AfterFunc(failedLaunchKeep, func() {
+				g.mu.Lock()
+				if g.Launches[p.Id] == l {
+					delete(g.Launches, p.Id)
+				}
+				g.mu.Unlock()
+			})
+			return
+		}
+		delete(g.Launches, p.Id)
+		bot.gang = g
+		g.connects[p.Id]++
+		ob := g.Bots[p.Id]
+		g.Bots[p.Id] = bot
+		g.mu.Unlock()
+		g.relink()
+		if ob != nil {
+			ob.Close()
+		}
+	}()
 	return nil
 }
 
+// Delete closes a bot or cancels its launch.
 func (g *Gang) Delete(id string) error {
 	g.mu.Lock()
 	b, ok := g.Bots[id]
 	if ok {
 		delete(g.Bots, id)
 	}
+	l := g.Launches[id]
+	delete(g.Launches, id)
 	g.mu.Unlock()
-	if !ok {
+	if l != nil {
+		l.cancel()
+		<-l.donec
+	}
+	if !ok && l == nil {
 		return fmt.Errorf("%s does not exist", id)
 	}
-	b.Close()
+	if b != nil {
+		g.relink()
+		b.Close()
+	}
+	return nil
+}
+
+// Shutdown cancels launches and shuts down every bot, giving their tasks
+// up to drain to finish.
+func (g *Gang) Shutdown(drain time.Duration, msg string) {
+	g.mu.Lock()
+	bots, launches := g.Bots, g.Launches
+	g.Bots, g.Launches = make(map[string]*Bot), make(map[string]*Launch)
+	g.mu.Unlock()
+	g.relink()
+	var wg sync.WaitGroup
+	for _, l := range launches {
+		l.cancel()
+		wg.Add(1)
+		go func(l *Launch) {
+			defer wg.Done()
+			<-l.donec
+		}(l)
+	}
+	for _, b := range bots {
+		wg.Add(1)
+		go func(b *Bot) {
+			defer wg.Done()
+			b.Shutdown(drain, msg)
+		}(b)
+	}
+	wg.Wait()
+}
+
+// relink routes relays between the bots now in the gang.
+func (g *Gang) relink() {
+	g.mu.RLock()
+	bots := make(map[string]*Bot, len(g.Bots))
+	for id, b := range g.Bots {
+		bots[id] = b
+	}
+	g.mu.RUnlock()
+	g.relays.set(bots)
+}
+
+// LookupLaunch returns the launch of a bot that has not come online.
+func (g *Gang) LookupLaunch(id string) *Launch {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if l := g.Launches[id]; l != nil {
+		ll := *l
+		return &ll
+	}
 	return nil
 }
 
@@ -64,3 +229,15 @@ func (g *Gang) Lookup(id string) *Bot {
 	g.mu.Lock()
 	return g.Bots[id]
 }
+
+// LookupToken finds the bot and running task holding a callback token.
+func (g *Gang) LookupToken(tok string) (*Bot, *Task) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for _, b := range g.Bots {
+		if t := b.Tasks.LookupToken(tok); t != nil {
+			return b, t
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,681 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+const (
+	// guardSweep is how often expired bans, locks, and offenses are cleared.
+	guardSweep          = time.Second
+	defaultForgetMs     = 60 * 60 * 1000
+	defaultFloodLockMs  = 60 * 1000
+	defaultFloodModes   = "+i"
+	defaultWarning      = "watch your language"
+	defaultKickReason   = "language"
+	massHighlightReason = "mass highlight"
+)
+
+// ErrGuardNotFound is returned when removing an entry not on its list.
+var ErrGuardNotFound = errors.New("guard entry not found")
+
+// guardActions are the actions a bad word filter escalates through.
+var guardActions = map[string]bool{"warn": true, "kick": true, "ban": true}
+
+// ChanGuard manages a channel the bot has ops in. Masks match prefixes
+// (nick!user@host) as in MatchMask, or are "$a:account" for a services
+// account.
+type ChanGuard struct {
+	Channel string
+	// AutoOp and AutoVoice are given +o and +v on join.
+	AutoOp    []string `json:",omitempty"`
+	AutoVoice []string `json:",omitempty"`
+	// Bans are kicked and banned on join.
+	Bans     []Ban     `json:",omitempty"`
+	BadWords *BadWords `json:",omitempty"`
+	// JoinFlood locks the channel when too many users join at once.
+	JoinFlood *JoinFlood `json:",omitempty"`
+	// MassHighlight kicks users naming this many channel members in
+	// one message.
+	MassHighlight int `json:",omitempty"`
+}
+
+type Ban struct {
+	Mask   string
+	Reason string `json:",omitempty"`
+	// Expires lifts the ban; bans without it are permanent.
+	Expires *Time `json:",omitempty"`
+}
+
+func (bn *Ban) expired(now time.Time) bool {
+	return bn.Expires != nil && !now.Before(bn.Expires.T())
+}
+
+// BadWords escalates on users saying words matching Match.
+type BadWords struct {
+	// Match are case insensitive regular expressions.
+	Match []string
+	// Actions are taken on each offense in turn, from warn, kick, and
+	// ban; the last repeats. Defaults to warn, kick, ban.
+	Actions []string `json:",omitempty"`
+	Warning string   `json:",omitempty"`
+	// ForgetMs clears a user's offenses after a quiet spell; default 1h.
+	ForgetMs int `json:",omitempty"`
+	// BanMs is how long bans last; zero is permanent.
+	BanMs int `json:",omitempty"`
+}
+
+// JoinFlood sets Modes for LockMs when Joins users join within WindowMs.
+type JoinFlood struct {
+	Joins    int
+	WindowMs int
+	Modes    string `json:",omitempty"`
+	LockMs   int    `json:",omitempty"`
+}
+
+// GuardEntry adds to or removes from a guarded channel's lists at run time.
+type GuardEntry struct {
+	Channel string
+	// List is one of op, voice, or ban.
+	List   string
+	Mask   string
+	Reason string `json:",omitempty"`
+	// DurationMs expires a ban; zero is permanent.
+	DurationMs int `json:",omitempty"`
+}
+
+// GuardStatus is a guarded channel's configuration and run time state.
+type GuardStatus struct {
+	ChanGuard
+	// Added are the entries added at run time.
+	Added  []GuardEntry `json:",omitempty"`
+	Locked bool         `json:",omitempty"`
+}
+
+type offense struct {
+	n    int
+	last time.Time
+}
+
+type guardChan struct {
+	cfg      ChanGuard
+	badwords []*regexp.Regexp
+	// ops, voices, and bans are added at run time.
+	ops, voices []string
+	bans        []Ban
+	// banned are bans the guard set on the channel, lifted when they expire.
+	banned   map[string]*Ban
+	offenses map[string]*offense
+	joins    []time.Time
+	unlockAt time.Time
+}
+
+func (gc *guardChan) allBans() []Ban {
+	return append(append([]Ban(nil), gc.cfg.Bans...), gc.bans...)
+}
+
+// Guard is the stage enforcing a bot's ChanGuards.
+type Guard struct {
+	b     *Bot
+	chans map[string]*guardChan
+	mu    sync.Mutex
+}
+
+func NewGuard(b *Bot) *Guard {
+	g := &Guard{b: b, chans: make(map[string]*guardChan)}
+	if err := g.update(b.Guards); err != nil {
+		log.Printf("[guard] %v", err)
+	}
+	return g
+}
+
+// compileGuard checks a channel's configuration, compiling its filter.
+func compileGuard(cg *ChanGuard) (res []*regexp.Regexp, err error) {
+	if cg.Channel == "" {
+		return nil, fmt.Errorf("guard missing Channel")
+	}
+	if bw := cg.BadWords; bw != nil {
+		for _, m := range bw.Match {
+			re, err := regexp.Compile("(?i)" + m)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, re)
+		}
+		for _, a := range bw.Actions {
+			if !guardActions[a] {
+				return nil, fmt.Errorf("unknown action %q", a)
+			}
+		}
+		if bw.ForgetMs < 0 || bw.BanMs < 0 {
+			return nil, fmt.Errorf("negative BadWords time")
+		}
+	}
+	if jf := cg.JoinFlood; jf != nil {
+		if jf.Joins <= 0 || jf.WindowMs <= 0 || jf.LockMs < 0 {
+			return nil, fmt.Errorf("JoinFlood needs positive Joins and WindowMs")
+		} else if jf.Modes != "" && (jf.Modes[0] != '+' || strings.ContainsAny(jf.Modes, " -")) {
+			return nil, fmt.Errorf("JoinFlood modes %q must be +flags", jf.Modes)
+		}
+	}
+	return res, nil
+}
+
+// update replaces the guarded channels, keeping run time state for
+// channels still guarded.
+func (g *Guard) update(cgs []ChanGuard) error {
+	chans := make(map[string]*guardChan)
+	var errs []string
+	for _, cg := range cgs {
+		res, err := compileGuard(&cg)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		chans[strings.ToLower(cg.Channel)] = &guardChan{cfg: cg, badwords: res}
+	}
+	g.mu.Lock()
+	for k, gc := range chans {
+		if old := g.chans[k]; old != nil {
+			old.cfg, old.badwords = gc.cfg, gc.badwords
+			chans[k] = old
+		} else {
+			gc.banned, gc.offenses = make(map[string]*Ban), make(map[string]*offense)
+		}
+	}
+	g.chans = chans
+	g.mu.Unlock()
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// run sweeps expired state until ctx is done.
+func (g *Guard) run(ctx context.Context) {
+	tick := time.NewTicker(guardSweep)
+	defer tick.Stop()
+	for {
+		select {
+		case now := <-tick.C:
+			g.sweep(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (g *Guard) sweep(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, gc := range g.chans {
+		ch := gc.cfg.Channel
+		for m, bn := range gc.banned {
+			if bn.expired(now) {
+				delete(gc.banned, m)
+				g.mode(ch, "-b", m)
+			}
+		}
+		bans := gc.bans[:0]
+		for _, bn := range gc.bans {
+			if !bn.expired(now) {
+				bans = append(bans, bn)
+			}
+		}
+		gc.bans = bans
+		if !gc.unlockAt.IsZero() && !now.Before(gc.unlockAt) {
+			gc.unlockAt = time.Time{}
+			g.mode(ch, "-"+floodModes(gc.cfg.JoinFlood)[1:])
+		}
+		if bw := gc.cfg.BadWords; bw != nil {
+			forget := time.Duration(orDefault(bw.ForgetMs, defaultForgetMs)) * time.Millisecond
+			for k, o := range gc.offenses {
+				if now.Sub(o.last) >= forget {
+					delete(gc.offenses, k)
+				}
+			}
+		}
+	}
+}
+
+func orDefault(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
+
+func floodModes(jf *JoinFlood) string {
+	if jf == nil || jf.Modes == "" {
+		return defaultFloodModes
+	}
+	return jf.Modes
+}
+
+func (g *Guard) Process(msg irc.Message) error {
+	if msg.Prefix == nil || len(msg.Params) == 0 {
+		return nil
+	}
+	switch msg.Command {
+	case irc.JOIN:
+		if g.isMe(msg.Prefix.Name) {
+			g.who(msg.Params[0])
+		} else {
+			g.join(msg.Params[0], msg.Prefix)
+		}
+	case irc.MODE:
+		// Learn everyone's hosts once the bot is given ops.
+		if len(msg.Params) > 2 && strings.HasPrefix(msg.Params[1], "+") && strings.Contains(msg.Params[1], "o") {
+			for _, n := range msg.Params[2:] {
+				if g.isMe(n) {
+					g.who(msg.Params[0])
+					break
+				}
+			}
+		}
+	case irc.RPL_ENDOFWHO:
+		// Enforce the lists on the users the WHO replies described.
+		if len(msg.Params) > 1 {
+			g.b.mu.RLock()
+			nick := g.b.Nick
+			g.b.mu.RUnlock()
+			if g.b.State.HasOp(msg.Params[1], nick) {
+				g.enforce(msg.Params[1])
+			}
+		}
+	case irc.PRIVMSG:
+		if len(msg.Params) > 1 {
+			g.privmsg(msg.Params[0], msg.Prefix, msg.Params[1])
+		}
+	case rplWhoisAccount:
+		if len(msg.Params) > 2 {
+			g.account(msg.Params[1], msg.Params[2])
+		}
+	}
+	return nil
+}
+
+// who asks the server about everyone on a guarded channel.
+func (g *Guard) who(ch string) {
+	g.mu.Lock()
+	gc := g.chans[strings.ToLower(ch)]
+	g.mu.Unlock()
+	if gc != nil {
+		g.write("who "+ch, g.b.State.who(ch))
+	}
+}
+
+func (g *Guard) isMe(nick string) bool {
+	g.b.mu.RLock()
+	defer g.b.mu.RUnlock()
+	return strings.EqualFold(nick, g.b.Nick)
+}
+
+// matchMask reports whether a user matches a mask; account is empty if unknown.
+func matchMask(mask string, pfx *irc.Prefix, account string) bool {
+	if a, ok := strings.CutPrefix(mask, "$a:"); ok {
+		return account != "" && strings.EqualFold(a, account)
+	}
+	return MatchMask(mask, pfx.String())
+}
+
+func matchAny(masks []string, pfx *irc.Prefix, account string) bool {
+	for _, m := range masks {
+		if matchMask(m, pfx, account) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAccountMask(ms ...[]string) bool {
+	for _, masks := range ms {
+		for _, m := range masks {
+			if strings.HasPrefix(m, "$a:") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *Guard) join(ch string, pfx *irc.Prefix) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gc := g.chans[strings.ToLower(ch)]
+	if gc == nil {
+		return
+	}
+	if jf := gc.cfg.JoinFlood; jf != nil {
+		now := time.Now()
+		window := now.Add(-time.Duration(jf.WindowMs) * time.Millisecond)
+		joins := gc.joins[:0]
+		for _, t := range append(gc.joins, now) {
+			if t.After(window) {
+				joins = append(joins, t)
+			}
+		}
+		gc.joins = joins
+		if len(joins) >= jf.Joins && gc.unlockAt.IsZero() {
+			log.Printf("[guard] %d joins on %s; locking", len(joins), ch)
+			gc.unlockAt = now.Add(time.Duration(orDefault(jf.LockMs, defaultFloodLockMs)) * time.Millisecond)
+			g.mode(ch, floodModes(jf))
+		}
+	}
+	if !g.apply(gc, ch, pfx, "") && hasAccountMask(gc.cfg.AutoOp, gc.cfg.AutoVoice, gc.ops, gc.voices) {
+		// The account may match where the host did not.
+		g.write("whois "+pfx.Name, irc.Message{Command: irc.WHOIS, Params: []string{pfx.Name}})
+	}
+}
+
+// apply bans or gives modes to a user on the channel, reporting whether
+// any mask matched.
+func (g *Guard) apply(gc *guardChan, ch string, pfx *irc.Prefix, account string) bool {
+	now := time.Now()
+	for _, bn := range gc.allBans() {
+		if !bn.expired(now) && matchMask(bn.Mask, pfx, account) {
+			g.kickban(gc, ch, pfx.Name, bn)
+			return true
+		}
+	}
+	switch {
+	case matchAny(gc.cfg.AutoOp, pfx, account) || matchAny(gc.ops, pfx, account):
+		g.mode(ch, "+o", pfx.Name)
+	case matchAny(gc.cfg.AutoVoice, pfx, account) || matchAny(gc.voices, pfx, account):
+		g.mode(ch, "+v", pfx.Name)
+	default:
+		return false
+	}
+	return true
+}
+
+// account applies account masks once WHOIS names a user's account.
+func (g *Guard) account(nick, account string) {
+	chans := g.b.State.nickChannels(nick)
+	pfx := g.b.State.prefix(nick)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, ch := range chans {
+		if gc := g.chans[strings.ToLower(ch)]; gc != nil {
+			g.apply(gc, ch, pfx, account)
+		}
+	}
+}
+
+// enforce applies the lists to everyone on the channel.
+func (g *Guard) enforce(ch string) {
+	g.b.State.RLock()
+	var pfxs []*irc.Prefix
+	var accts []string
+	if r, ok := g.b.State.Channels[ch]; ok {
+		for n := range r.Users {
+			if u := g.b.State.Users[n]; u != nil && u.Host != "" {
+				pfxs = append(pfxs, &irc.Prefix{Name: n, User: u.User, Host: u.Host})
+				accts = append(accts, u.Account)
+			}
+		}
+	}
+	g.b.State.RUnlock()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gc := g.chans[strings.ToLower(ch)]
+	if gc == nil {
+		return
+	}
+	for i, pfx := range pfxs {
+		if !g.isMe(pfx.Name) {
+			g.apply(gc, ch, pfx, accts[i])
+		}
+	}
+}
+
+func (g *Guard) privmsg(ch string, pfx *irc.Prefix, txt string) {
+	if g.isMe(pfx.Name) || g.b.IsOwner(pfx) {
+		return
+	}
+	if typ, args, ok := ctcpSplit(txt); ok {
+		if typ != "ACTION" {
+			return
+		}
+		txt = args
+	}
+	txt = stripFormat(txt)
+	nicks := g.b.State.channelNicks(ch)
+	acct := g.b.State.account(pfx.Name)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gc := g.chans[strings.ToLower(ch)]
+	if gc == nil || matchAny(gc.cfg.AutoOp, pfx, acct) || matchAny(gc.ops, pfx, acct) {
+		return
+	}
+	if n := gc.cfg.MassHighlight; n > 0 && highlights(txt, pfx.Name, nicks) >= n {
+		g.kick(ch, pfx.Name, massHighlightReason)
+		return
+	}
+	for _, re := range gc.badwords {
+		if re.MatchString(txt) {
+			g.offend(gc, ch, pfx)
+			return
+		}
+	}
+}
+
+// highlights counts the distinct channel members named in txt, besides from.
+func highlights(txt, from string, nicks map[string]bool) int {
+	seen := make(map[string]bool)
+	for _, w := range strings.Fields(txt) {
+		w = strings.ToLower(strings.TrimRight(w, ":,.!?"))
+		if nicks[w] && w != strings.ToLower(from) {
+			seen[w] = true
+		}
+	}
+	return len(seen)
+}
+
+// offend escalates on a user's latest bad word.
+func (g *Guard) offend(gc *guardChan, ch string, pfx *irc.Prefix) {
+	bw := gc.cfg.BadWords
+	k := strings.ToLower(pfx.User + "@" + pfx.Host)
+	o := gc.offenses[k]
+	if o == nil {
+		o = &offense{}
+		gc.offenses[k] = o
+	}
+	o.n++
+	o.last = time.Now()
+	acts := bw.Actions
+	if len(acts) == 0 {
+		acts = []string{"warn", "kick", "ban"}
+	}
+	act := acts[len(acts)-1]
+	if o.n <= len(acts) {
+		act = acts[o.n-1]
+	}
+	switch act {
+	case "warn":
+		warn := bw.Warning
+		if warn == "" {
+			warn = defaultWarning
+		}
+		g.write("warn "+pfx.Name, irc.Message{Command: irc.NOTICE, Params: []string{pfx.Name, warn}})
+	case "kick":
+		g.kick(ch, pfx.Name, defaultKickReason)
+	case "ban":
+		bn := Ban{Mask: "*!*@" + pfx.Host, Reason: defaultKickReason}
+		if bw.BanMs > 0 {
+			t := Time(time.Now().Add(time.Duration(bw.BanMs) * time.Millisecond))
+			bn.Expires = &t
+		}
+		gc.bans = append(gc.bans, bn)
+		g.kickban(gc, ch, pfx.Name, bn)
+	}
+}
+
+func (g *Guard) kickban(gc *guardChan, ch, nick string, bn Ban) {
+	if !g.opped(ch) {
+		return
+	}
+	gc.banned[bn.Mask] = &bn
+	reason := bn.Reason
+	if reason == "" {
+		reason = "banned"
+	}
+	// Ban first so the user cannot rejoin in between.
+	g.write("kickban "+nick,
+		irc.Message{Command: irc.MODE, Params: []string{ch, "+b", bn.Mask}},
+		irc.Message{Command: irc.KICK, Params: []string{ch, nick, reason}})
+}
+
+func (g *Guard) kick(ch, nick, reason string) {
+	if !g.opped(ch) {
+		return
+	}
+	g.write("kick "+nick, irc.Message{Command: irc.KICK, Params: []string{ch, nick, reason}})
+}
+
+// mode sets channel modes, reporting false if the bot has no ops.
+func (g *Guard) mode(ch, modes string, args ...string) bool {
+	if !g.opped(ch) {
+		return false
+	}
+	g.write("mode "+modes, irc.Message{Command: irc.MODE, Params: append([]string{ch, modes}, args...)})
+	return true
+}
+
+func (g *Guard) opped(ch string) bool {
+	g.b.mu.RLock()
+	nick := g.b.Nick
+	g.b.mu.RUnlock()
+	if g.b.State.HasOp(ch, nick) {
+		return true
+	}
+	log.Printf("[guard] no ops on %s", ch)
+	return false
+}
+
+func (g *Guard) write(cmd string, msgs ...irc.Message) {
+	g.b.Tasks.Run("guard", cmd, func(t *Task) error {
+		t.mu.Lock()
+		t.Target = msgs[0].Params[0]
+		t.mu.Unlock()
+		for _, msg := range msgs {
+			if err := t.Write(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// List returns the guarded channels, sorted by name.
+func (g *Guard) List() []GuardStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ret := make([]GuardStatus, 0, len(g.chans))
+	for _, gc := range g.chans {
+		st := GuardStatus{ChanGuard: gc.cfg, Locked: !gc.unlockAt.IsZero()}
+		ch := gc.cfg.Channel
+		for _, m := range gc.ops {
+			st.Added = append(st.Added, GuardEntry{Channel: ch, List: "op", Mask: m})
+		}
+		for _, m := range gc.voices {
+			st.Added = append(st.Added, GuardEntry{Channel: ch, List: "voice", Mask: m})
+		}
+		for _, bn := range gc.bans {
+			e := GuardEntry{Channel: ch, List: "ban", Mask: bn.Mask, Reason: bn.Reason}
+			if bn.Expires != nil {
+				e.DurationMs = int(time.Until(bn.Expires.T()).Milliseconds())
+			}
+			st.Added = append(st.Added, e)
+		}
+		ret = append(ret, st)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Channel < ret[j].Channel })
+	return ret
+}
+
+// Add adds an entry to a guarded channel and applies it to the channel.
+func (g *Guard) Add(e GuardEntry) error {
+	if e.Mask == "" {
+		return fmt.Errorf("missing mask")
+	} else if e.DurationMs < 0 {
+		return fmt.Errorf("negative DurationMs")
+	}
+	g.mu.Lock()
+	gc := g.chans[strings.ToLower(e.Channel)]
+	if gc == nil {
+		g.mu.Unlock()
+		return fmt.Errorf("%q is not guarded", e.Channel)
+	}
+	switch e.List {
+	case "op":
+		gc.ops = append(gc.ops, e.Mask)
+	case "voice":
+		gc.voices = append(gc.voices, e.Mask)
+	case "ban":
+		bn := Ban{Mask: e.Mask, Reason: e.Reason}
+		if e.DurationMs > 0 {
+			t := Time(time.Now().Add(time.Duration(e.DurationMs) * time.Millisecond))
+			bn.Expires = &t
+		}
+		gc.bans = append(gc.bans, bn)
+	default:
+		g.mu.Unlock()
+		return fmt.Errorf("unknown list %q", e.List)
+	}
+	ch := gc.cfg.Channel
+	g.mu.Unlock()
+	g.enforce(ch)
+	return nil
+}
+
+// Remove removes a run time entry, lifting it if it was a ban.
+func (g *Guard) Remove(e GuardEntry) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	gc := g.chans[strings.ToLower(e.Channel)]
+	if gc == nil {
+		return fmt.Errorf("%q is not guarded", e.Channel)
+	}
+	drop := func(ms []string) ([]string, bool) {
+		for i, m := range ms {
+			if m == e.Mask {
+				return append(ms[:i:i], ms[i+1:]...), true
+			}
+		}
+		return ms, false
+	}
+	var ok bool
+	switch e.List {
+	case "op":
+		gc.ops, ok = drop(gc.ops)
+	case "voice":
+		gc.voices, ok = drop(gc.voices)
+	case "ban":
+		for i, bn := range gc.bans {
+			if bn.Mask == e.Mask {
+				gc.bans, ok = append(gc.bans[:i:i], gc.bans[i+1:]...), true
+				break
+			}
+		}
+		if _, set := gc.banned[e.Mask]; set {
+			delete(gc.banned, e.Mask)
+			g.mode(gc.cfg.Channel, "-b", e.Mask)
+		}
+	default:
+		return fmt.Errorf("unknown list %q", e.List)
+	}
+	if !ok {
+		return ErrGuardNotFound
+	}
+	return nil
+}
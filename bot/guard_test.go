@@ -0,0 +1,100 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+func TestGuard(t *testing.T) {
+	b, c := testBot(t, irctest.NewServer(t), Profile{ProfileLogin: ProfileLogin{Nick: "gb"}, Chans: []string{"#g"},
+		Guards: []ChanGuard{{Channel: "#g", AutoOp: []string{"alice!*@*"}, Bans: []Ban{{Mask: "eve!*@*", Reason: "go away"}},
+			BadWords: &BadWords{Match: []string{`\bdarn\b`}}, MassHighlight: 2}}})
+	c.Send(":irctest MODE #g +v gb", ":irctest MODE #g +o gb")
+	waitFor(t, "ops", func() bool { return b.State.HasOp("#g", "gb") })
+
+	c.Join("eve", "#g")
+	c.Expect(t, irc.MODE, "#g", "+b", "eve!*@*")
+	c.Expect(t, irc.KICK, "#g", "eve", "go away")
+	c.Join("alice", "#g")
+	c.Expect(t, irc.MODE, "#g", "+o", "alice")
+
+	c.Privmsg("bob", "#g", "oh DARN it")
+	c.Expect(t, irc.NOTICE, "bob", defaultWarning)
+	c.Privmsg("bob", "#g", "darn")
+	c.Expect(t, irc.KICK, "#g", "bob")
+	c.Privmsg("bob", "#g", "darn!")
+	c.Expect(t, irc.MODE, "#g", "+b", "*!*@"+irctest.ServerName)
+	c.Expect(t, irc.KICK, "#g", "bob")
+
+	c.Privmsg("carol", "#g", "alice: gb: look")
+	c.Expect(t, irc.KICK, "#g", "carol", massHighlightReason)
+
+	if err := b.Guard.Remove(GuardEntry{Channel: "#g", List: "ban", Mask: "*!*@" + irctest.ServerName}); err != nil {
+		t.Fatal(err)
+	}
+	c.Expect(t, irc.MODE, "#g", "-b", "*!*@"+irctest.ServerName)
+	if err := b.Guard.Add(GuardEntry{Channel: "#g", List: "ban", Mask: "alice!*@*"}); err != nil {
+		t.Fatal(err)
+	}
+	c.Expect(t, irc.MODE, "#g", "+b", "alice!*@*")
+	if err := b.Guard.Remove(GuardEntry{Channel: "#g", List: "ban", Mask: "alice!*@*"}); err != nil {
+		t.Fatal(err)
+	}
+	c.Expect(t, irc.MODE, "#g", "-b", "alice!*@*")
+	if err := b.Guard.Remove(GuardEntry{Channel: "#g", List: "ban", Mask: "alice!*@*"}); err != ErrGuardNotFound {
+		t.Errorf("expected ErrGuardNotFound, got %v", err)
+	}
+	if err := b.Guard.Add(GuardEntry{Channel: "#nope", List: "op", Mask: "*"}); err == nil {
+		t.Errorf("expected unguarded channel error")
+	}
+}
+
+func TestGuardWho(t *testing.T) {
+	s := irctest.NewServer(t)
+	s.AddUser("#w", "eve")
+	s.AddUser("#w", "alice")
+	b, c := testBot(t, s, Profile{ProfileLogin: ProfileLogin{Nick: "wb"}, Chans: []string{"#w"},
+		Guards: []ChanGuard{{Channel: "#w", AutoOp: []string{"$a:al"}, Bans: []Ban{{Mask: "*!*@bad.example", Reason: "bad host"}}}}})
+	c.Expect(t, irc.WHO, "#w")
+	c.Send(":irctest 352 wb #w eve bad.example irctest eve H :0 Eve",
+		":irctest 315 wb #w :End of WHO list",
+		":irctest 005 wb WHOX :are supported by this server",
+		":irctest MODE #w +o wb")
+	waitFor(t, "ops", func() bool { return b.State.HasOp("#w", "wb") })
+	c.Expect(t, irc.WHO, "#w", whoxFields)
+	c.Send(":irctest 354 wb "+whoxToken+" alice good.example alice al",
+		":irctest 354 wb "+whoxToken+" eve bad.example eve 0",
+		":irctest 315 wb #w :End of WHO list")
+	// Users are enforced in no particular order.
+	want := map[string]bool{"MODE #w +b *!*@bad.example": true, "KICK #w eve bad host": true, "MODE #w +o alice": true}
+	waitFor(t, "enforcement", func() bool {
+		n := 0
+		for _, msg := range c.Sent() {
+			if want[strings.Join(append([]string{msg.Command}, msg.Params...), " ")] {
+				n++
+			}
+		}
+		return n == len(want)
+	})
+}
+
+func TestStateModes(t *testing.T) {
+	s := NewState()
+	s.Process(irc.Message{Prefix: &irc.Prefix{Name: "srv"}, Command: irc.RPL_NAMREPLY,
+		Params: []string{"me", "=", "#c", "@+ann bob"}})
+	s.Process(irc.Message{Prefix: &irc.Prefix{Name: "a"}, Command: irc.MODE,
+		Params: []string{"#c", "+kv-o", "key", "bob", "ann"}})
+	if m := s.Channels["#c"].Users["ann"].Mode; m != "+" {
+		t.Errorf("ann has mode %q", m)
+	}
+	if s.HasOp("#c", "ann") || s.HasOp("#c", "bob") {
+		t.Errorf("expected no ops")
+	}
+	if m := s.Channels["#c"].Users["bob"].Mode; m != "+" {
+		t.Errorf("bob has mode %q", m)
+	}
+}
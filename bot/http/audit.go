@@ -0,0 +1,60 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+// serveAudit queries a bot's audit log with the parameters user, script,
+// since and until (RFC3339, or a duration before now), and limit.
+func (h *botHandler) serveAudit(b *bot.Bot, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "bad request", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.task != nil {
+		http.Error(w, errForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	errWrap(w, r, func() error {
+		if b.Audit == nil {
+			return errors.New("audit log disabled")
+		}
+		v := r.URL.Query()
+		q := bot.AuditQuery{User: v.Get("user"), Script: v.Get("script")}
+		var err error
+		if q.Since, err = parseAuditTime(v.Get("since")); err != nil {
+			return err
+		}
+		if q.Until, err = parseAuditTime(v.Get("until")); err != nil {
+			return err
+		}
+		if l := v.Get("limit"); l != "" {
+			if q.Limit, err = strconv.Atoi(l); err != nil {
+				return err
+			}
+		}
+		recs, err := b.Audit.Query(q)
+		if err != nil {
+			return err
+		}
+		if recs == nil {
+			recs = []bot.AuditRecord{}
+		}
+		return writeJSON(w, recs)
+	})
+}
+
+func parseAuditTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -2,21 +2,31 @@ package http
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
-	"path"
 	"strings"
 
 	"github.com/chzchzchz/sitbot/bot"
+	"github.com/chzchzchz/sitbot/kv"
 	"gopkg.in/sorcix/irc.v2"
 )
 
+var errForbidden = errors.New("forbidden")
+
 type botHandler struct {
-	g *bot.Gang
+	g  *bot.Gang
+	kv *kv.Dir
+	// task is set when a script token authenticated the request.
+	task *bot.Task
 }
 
 func (h *botHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	_, id := path.Split(r.URL.Path)
+	id, sub, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if sub != "" {
+		h.serveSub(id, sub, w, r)
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		errWrap(w, r, func() error { return h.get(id, w, r) })
@@ -29,6 +39,30 @@ func (h *botHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveSub serves a bot's resources under /bot/<id>/.
+func (h *botHandler) serveSub(id, sub string, w http.ResponseWriter, r *http.Request) {
+	b := h.g.Lookup(id)
+	if b == nil {
+		http.Error(w, "no such bot", http.StatusNotFound)
+		return
+	}
+	res, arg, _ := strings.Cut(sub, "/")
+	switch res {
+	case "timer":
+		h.serveTimer(b, arg, w, r)
+	case "kv":
+		h.serveKV(b, arg, w, r)
+	case "audit":
+		h.serveAudit(b, w, r)
+	case "chat":
+		h.serveChat(b, w, r)
+	case "guard":
+		h.serveGuard(b, w, r)
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+	}
+}
+
 type BotPostMessage struct {
 	TaskId bot.TaskId
 	irc.Message
@@ -63,6 +97,8 @@ func (h *botHandler) post(id string, w http.ResponseWriter, r *http.Request, b [
 func (h *botHandler) postMessage(b *bot.Bot, m *BotPostMessage) error {
 	if len(m.Command) == 0 || b == nil {
 		return io.EOF
+	} else if h.task != nil && !h.task.Allows(m.TaskId, m.Message) {
+		return errForbidden
 	} else if m.Command == irc.KILL && len(m.Params) == 0 {
 		return b.Tasks.Kill(m.TaskId)
 	}
@@ -70,15 +106,33 @@ func (h *botHandler) postMessage(b *bot.Bot, m *BotPostMessage) error {
 }
 
 func (h *botHandler) get(id string, w http.ResponseWriter, r *http.Request) error {
-	bot := h.g.Lookup(id)
-	if bot == nil {
+	b := h.g.Lookup(id)
+	if b == nil {
+		if l := h.g.LookupLaunch(id); l != nil && h.task == nil {
+			return writeJSON(w, l)
+		}
 		return io.EOF
 	}
-	b, err := json.Marshal(bot)
+	var v interface{} = b
+	if h.task != nil {
+		// Keep login credentials from scripts.
+		v = struct {
+			Id    string
+			Nick  string
+			State *bot.State
+			Tasks *bot.Tasks
+		}{b.Id, b.Nick, b.State, b.Tasks}
+	}
+	// Hold the bot's profile and channel state steady while encoding them.
+	b.RLock()
+	b.State.RLock()
+	out, err := json.Marshal(v)
+	b.State.RUnlock()
+	b.RUnlock()
 	if err != nil {
 		return err
 	}
 	w.Header().Set("Content-Type", "application/json")
-	_, err = w.Write(b)
+	_, err = w.Write(out)
 	return err
 }
@@ -0,0 +1,34 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+type ChatPost struct {
+	Nick string
+}
+
+// serveChat offers a user an admin console over DCC CHAT.
+func (h *botHandler) serveChat(b *bot.Bot, w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "bad request", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.task != nil {
+		http.Error(w, errForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	postWrap(w, r, func(body []byte) error {
+		var cp ChatPost
+		if err := json.Unmarshal(body, &cp); err != nil {
+			return err
+		} else if cp.Nick == "" {
+			return errors.New("no nick")
+		}
+		return b.Transfers.Chat(cp.Nick)
+	})
+}
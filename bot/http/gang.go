@@ -4,14 +4,20 @@ import (
 	"net/http"
 
 	"github.com/chzchzchz/sitbot/bot"
+	"github.com/chzchzchz/sitbot/kv"
 )
 
-func NewGangHandler(g *bot.Gang) http.Handler {
+// NewGangHandler serves the gang's control API; kvd holds the bots' script
+// key-value stores, or is nil to disable them.
+func NewGangHandler(g *bot.Gang, kvd *kv.Dir) http.Handler {
 	mux := http.NewServeMux()
 	th := newTemplateHandler(g)
 	mux.Handle("/", http.StripPrefix("/", th))
 
-	bh := &botHandler{g: g}
+	mux.Handle("/test", &trialHandler{g: g})
+	mux.Handle("/metrics", &metricsHandler{g: g})
+
+	bh := &botHandler{g: g, kv: kvd}
 	mux.Handle("/bot/", http.StripPrefix("/bot", bh))
 
 	return mux
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+// serveGuard lists a bot's guarded channels, adds entries by POST, and
+// removes them by DELETE with the parameters channel, list, and mask.
+func (h *botHandler) serveGuard(b *bot.Bot, w http.ResponseWriter, r *http.Request) {
+	if h.task != nil {
+		http.Error(w, errForbidden.Error(), http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		errWrap(w, r, func() error { return writeJSON(w, b.Guard.List()) })
+	case http.MethodDelete:
+		v := r.URL.Query()
+		err := b.Guard.Remove(bot.GuardEntry{Channel: v.Get("channel"), List: v.Get("list"), Mask: v.Get("mask")})
+		if err == bot.ErrGuardNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	case http.MethodPost:
+		postWrap(w, r, func(body []byte) error {
+			var e bot.GuardEntry
+			if err := json.Unmarshal(body, &e); err != nil {
+				return err
+			}
+			if err := b.Guard.Add(e); err != nil {
+				return err
+			}
+			return ok(w)
+		})
+	default:
+		http.Error(w, "bad request", http.StatusMethodNotAllowed)
+	}
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"encoding/json"
 	"io"
 	"io/ioutil"
 	"log"
@@ -36,6 +37,16 @@ func ok(w http.ResponseWriter) error {
 	return err
 }
 
+func writeJSON(w http.ResponseWriter, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, err = w.Write(b)
+	return err
+}
+
 type logHandler struct {
 	h   http.Handler
 	pfx string
@@ -0,0 +1,105 @@
+package http
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzchzchz/sitbot/bot"
+	"github.com/chzchzchz/sitbot/kv"
+)
+
+// serveKV serves a bot's key-value store as /bot/<id>/kv/<ns>/<key>. Scripts
+// may only use the namespace named after themselves.
+func (h *botHandler) serveKV(b *bot.Bot, arg string, w http.ResponseWriter, r *http.Request) {
+	ns, key, _ := strings.Cut(arg, "/")
+	if h.kv == nil {
+		http.Error(w, "key-value store disabled", http.StatusNotFound)
+		return
+	} else if ns == "" {
+		http.Error(w, "no namespace", http.StatusBadRequest)
+		return
+	} else if h.task != nil && ns != h.task.Script() {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	defer r.Body.Close()
+	s, err := h.kv.Open(b.Id)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := h.kvDo(s, ns+"/", key, w, r); err != nil {
+		code := http.StatusBadRequest
+		switch err {
+		case kv.ErrNotFound:
+			code = http.StatusNotFound
+		case kv.ErrConflict:
+			code = http.StatusConflict
+		}
+		http.Error(w, err.Error(), code)
+	}
+}
+
+func (h *botHandler) kvDo(s *kv.Store, pfx, key string, w http.ResponseWriter, r *http.Request) error {
+	q := r.URL.Query()
+	var ver uint64
+	if v := q.Get("version"); v != "" {
+		var err error
+		if ver, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return err
+		}
+	}
+	if key == "" && r.Method != http.MethodGet {
+		return errors.New("no key")
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if key == "" {
+			ents := s.List(pfx + q.Get("prefix"))
+			for i := range ents {
+				ents[i].Key = strings.TrimPrefix(ents[i].Key, pfx)
+			}
+			return writeJSON(w, ents)
+		}
+		e, err := s.Get(pfx + key)
+		if err != nil {
+			return err
+		}
+		e.Key = key
+		return writeJSON(w, e)
+	case http.MethodPut, http.MethodPost:
+		var ttl time.Duration
+		if v := q.Get("ttl"); v != "" {
+			var err error
+			if ttl, err = time.ParseDuration(v); err != nil {
+				return err
+			}
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		var e kv.Entry
+		if q.Has("version") {
+			e, err = s.CompareAndSwap(pfx+key, string(b), ver, ttl)
+		} else {
+			e, err = s.Put(pfx+key, string(b), ttl)
+		}
+		if err != nil {
+			return err
+		}
+		e.Key = key
+		return writeJSON(w, e)
+	case http.MethodDelete:
+		if err := s.Delete(pfx+key, ver); err != nil {
+			return err
+		}
+		return ok(w)
+	}
+	http.Error(w, "bad request", http.StatusMethodNotAllowed)
+	return nil
+}
@@ -0,0 +1,20 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+type metricsHandler struct {
+	g *bot.Gang
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "bad request", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	h.g.WriteMetrics(w)
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/chzchzchz/sitbot/bot"
+	"github.com/chzchzchz/sitbot/kv"
+)
+
+// Token returns the script token from a request's bearer authorization.
+func Token(r *http.Request) string {
+	if tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return tok
+	}
+	return ""
+}
+
+// scriptHandler serves callbacks authenticated by a task's token, limited
+// to the task's bot and targets.
+type scriptHandler struct {
+	g  *bot.Gang
+	kv *kv.Dir
+}
+
+func NewScriptHandler(g *bot.Gang, kvd *kv.Dir) http.Handler {
+	return &scriptHandler{g: g, kv: kvd}
+}
+
+func (h *scriptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, t := h.g.LookupToken(Token(r))
+	if t == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	p, sub := "/bot/"+b.Id, false
+	if r.URL.Path != p {
+		if !strings.HasPrefix(r.URL.Path, p+"/") {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		sub = true
+	}
+	// Scripts may not delete their bot, only its resources.
+	if r.Method == http.MethodDelete && !sub {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	http.StripPrefix("/bot", &botHandler{g: h.g, kv: h.kv, task: t}).ServeHTTP(w, r)
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot"
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+// scriptSandbox runs the test from a directory whose sandbox saves the
+// task's token and waits for a file named done.
+func scriptSandbox(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, bot.ScriptDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sb := "#!/bin/sh\necho \"$SITBOT_TOKEN\" > token.tmp && mv token.tmp token\nwhile [ ! -f done ]; do sleep 0.01; done\n"
+	if err := os.WriteFile(filepath.Join(dir, bot.ScriptDir, "sandbox"), []byte(sb), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(irctest.Timeout); !f(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestScriptToken(t *testing.T) {
+	scriptSandbox(t)
+	s := irctest.NewServer(t)
+	g := bot.NewGang()
+	defer g.Shutdown(0, "bye")
+	p := bot.Profile{Id: "s", ProfileLogin: bot.ProfileLogin{ServerURL: s.URL(), Nick: "tb"}, RateMs: 1,
+		Chans: []string{"#s"}, Patterns: []bot.Pattern{{Match: "^!cb", Template: "cb"}}}
+	if err := g.Post(p); err != nil {
+		t.Fatal(err)
+	}
+	c := s.Client(t)
+	c.Welcomed(t)
+	c.Expect(t, irc.JOIN, "#s")
+	waitFor(t, "bot online", func() bool { return g.Lookup("s") != nil })
+	c.Privmsg("alice", "#s", "!cb")
+	var tok string
+	waitFor(t, "token", func() bool {
+		b, _ := os.ReadFile("token")
+		tok = strings.TrimSpace(string(b))
+		return tok != ""
+	})
+
+	srv := httptest.NewServer(NewScriptHandler(g, nil))
+	defer srv.Close()
+	do := func(method, path, tok, body string) int {
+		req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok != "" {
+			req.Header.Set("Authorization", "Bearer "+tok)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	say := func(tgt string) string { return `{"Command" : "PRIVMSG", "Params" : ["` + tgt + `", "hi"]}` }
+	tests := []struct {
+		method, path, tok, body string
+		want                    int
+	}{
+		{http.MethodGet, "/bot/s", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/bot/s", "bogus", "", http.StatusUnauthorized},
+		{http.MethodGet, "/bot/s", tok, "", http.StatusOK},
+		{http.MethodGet, "/bot/other", tok, "", http.StatusForbidden},
+		{http.MethodGet, "/bot/s2/timer", tok, "", http.StatusForbidden},
+		{http.MethodDelete, "/bot/s", tok, "", http.StatusForbidden},
+		{http.MethodPost, "/bot/s", tok, say("#elsewhere"), http.StatusBadRequest},
+		{http.MethodPost, "/bot/s", tok, say("#s"), http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := do(tt.method, tt.path, tt.tok, tt.body); got != tt.want {
+			t.Errorf("%s %s got %d, want %d", tt.method, tt.path, got, tt.want)
+		}
+	}
+	c.Expect(t, irc.PRIVMSG, "#s", "hi")
+
+	// The token dies with its task.
+	if err := os.WriteFile("done", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "token revoked", func() bool { return do(http.MethodGet, "/bot/s", tok, "") == http.StatusUnauthorized })
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"bytes"
 	"html/template"
 	"net/http"
 	"sync"
@@ -50,13 +51,36 @@ func (h *templateHandler) post(w http.ResponseWriter, r *http.Request, b []byte)
 		h.tmpl = tmpl
 		h.mu.Unlock()
 	} else {
-		p, err := bot.UnmarshalProfile(b)
+		ps, err := bot.DecodeProfiles(bytes.NewReader(b))
 		if err != nil {
 			return err
 		}
-		if err = h.g.Post(*p); err != nil {
-			return err
+		probs := bot.ValidateProfiles(ps, bot.ScriptDir)
+		if r.URL.Query().Get("dryrun") != "" {
+			if probs == nil {
+				probs = bot.Problems{}
+			} else {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnprocessableEntity)
+			}
+			return writeJSON(w, struct{ Problems bot.Problems }{probs})
+		} else if len(probs) > 0 {
+			return probs
+		}
+		// New bots connect in the background; GET /bot/<id> for progress.
+		ids, code := make([]string, len(ps)), http.StatusOK
+		for i, p := range ps {
+			if err = h.g.Post(*p); err != nil {
+				return err
+			}
+			if h.g.LookupLaunch(p.Id) != nil {
+				code = http.StatusAccepted
+			}
+			ids[i] = p.Id
 		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		return writeJSON(w, struct{ Ids []string }{ids})
 	}
 	return ok(w)
 }
@@ -0,0 +1,36 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chzchzchz/sitbot/bot"
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+func TestPostStatus(t *testing.T) {
+	s := irctest.NewServer(t)
+	g := bot.NewGang()
+	defer g.Shutdown(0, "bye")
+	srv := httptest.NewServer(NewGangHandler(g, nil))
+	defer srv.Close()
+	post := func() int {
+		prof := `{"Id" : "p", "ServerURL" : "` + s.URL() + `", "Nick" : "pb", "RateMs" : 1}`
+		resp, err := http.Post(srv.URL+"/", "application/json", strings.NewReader(prof))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := post(); code != http.StatusAccepted {
+		t.Fatalf("got %d launching, want %d", code, http.StatusAccepted)
+	}
+	s.Client(t).Welcomed(t)
+	waitFor(t, "bot online", func() bool { return g.Lookup("p") != nil })
+	if code := post(); code != http.StatusOK {
+		t.Fatalf("got %d updating in place, want %d", code, http.StatusOK)
+	}
+}
@@ -0,0 +1,39 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/chzchzchz/sitbot/bot"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func (h *botHandler) serveTimer(b *bot.Bot, name string, w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		errWrap(w, r, func() error { return writeJSON(w, b.Timers.ListFor(h.task)) })
+	case http.MethodDelete:
+		if err := b.Timers.CancelFor(h.task, name); err == bot.ErrTimerNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	case http.MethodPost:
+		postWrap(w, r, func(body []byte) error {
+			var tm bot.Timer
+			if err := json.Unmarshal(body, &tm); err != nil {
+				return err
+			}
+			msg := irc.Message{Command: irc.PRIVMSG, Params: []string{tm.Target}}
+			if h.task != nil && !h.task.Allows(0, msg) {
+				return errForbidden
+			}
+			if err := b.Timers.AddFor(h.task, tm); err != nil {
+				return err
+			}
+			return ok(w)
+		})
+	default:
+		http.Error(w, "bad request", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+// TrialPost tests a message against a running bot's profile, by Id, or
+// against a posted Profile.
+type TrialPost struct {
+	Id      string       `json:",omitempty"`
+	Profile *bot.Profile `json:",omitempty"`
+	bot.TrialRequest
+}
+
+type trialHandler struct {
+	g *bot.Gang
+}
+
+func (h *trialHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "bad request", http.StatusMethodNotAllowed)
+		return
+	}
+	postWrap(w, r, func(b []byte) error {
+		var tp TrialPost
+		if err := json.Unmarshal(b, &tp); err != nil {
+			return err
+		}
+		p := tp.Profile
+		if p == nil {
+			bb := h.g.Lookup(tp.Id)
+			if bb == nil {
+				return fmt.Errorf("%s does not exist", tp.Id)
+			}
+			bb.RLock()
+			prof := bb.Profile
+			bb.RUnlock()
+			p = &prof
+		}
+		res, err := p.Trial(r.Context(), tp.TrialRequest)
+		if err != nil {
+			return err
+		}
+		return writeJSON(w, res)
+	})
+}
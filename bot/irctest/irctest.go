@@ -0,0 +1,402 @@
+// Package irctest runs a fake IRC server in-process for testing bots.
+//
+// The server speaks enough of RFC 1459 for a bot to register, join
+// channels, and list names. Tests script what other users say through the
+// bot's Client and assert on what the bot sends back.
+package irctest
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// ServerName prefixes the server's numeric replies.
+const ServerName = "irctest"
+
+// Timeout bounds waiting on the bot.
+var Timeout = 5 * time.Second
+
+// Server accepts bots on a local port.
+type Server struct {
+	ln      net.Listener
+	clientc chan *Client
+	clients []*Client
+	// chans holds the nicks of users in each channel, besides bots.
+	chans map[string]map[string]bool
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+}
+
+// NewServer starts a server, closed when the test ends.
+func NewServer(t testing.TB) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{ln: ln, clientc: make(chan *Client, 16), chans: make(map[string]map[string]bool)}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := newClient(s, conn)
+			s.mu.Lock()
+			s.clients = append(s.clients, c)
+			s.mu.Unlock()
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				c.serve()
+			}()
+			s.clientc <- c
+		}
+	}()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Addr is the server's host:port.
+func (s *Server) Addr() string { return s.ln.Addr().String() }
+
+// URL is the server's address as a profile ServerURL.
+func (s *Server) URL() string { return "irc://" + s.Addr() }
+
+// Client waits for the next bot to connect.
+func (s *Server) Client(t testing.TB) *Client {
+	t.Helper()
+	select {
+	case c := <-s.clientc:
+		return c
+	case <-time.After(Timeout):
+		t.Fatal("no client connected")
+	}
+	return nil
+}
+
+// AddUser puts nick in a channel's NAMES without telling anyone.
+func (s *Server) AddUser(ch, nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := strings.ToLower(ch)
+	if s.chans[k] == nil {
+		s.chans[k] = make(map[string]bool)
+	}
+	s.chans[k][nick] = true
+}
+
+// RemoveUser takes nick out of a channel's NAMES.
+func (s *Server) RemoveUser(ch, nick string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.chans[strings.ToLower(ch)], nick)
+}
+
+func (s *Server) names(ch string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ns []string
+	for n := range s.chans[strings.ToLower(ch)] {
+		ns = append(ns, n)
+	}
+	return ns
+}
+
+// Close hangs up on every bot and stops listening.
+func (s *Server) Close() {
+	s.ln.Close()
+	s.mu.Lock()
+	cs := s.clients
+	s.mu.Unlock()
+	for _, c := range cs {
+		c.Close()
+	}
+	s.wg.Wait()
+}
+
+// Client is the server's end of a bot's connection.
+type Client struct {
+	s    *Server
+	conn net.Conn
+	nick string
+	user string
+	// chans are the channels the bot has joined.
+	chans map[string]bool
+	// msgs are the messages the bot has sent; Expect reads from next.
+	msgs    []*irc.Message
+	next    int
+	notifyc chan struct{}
+	welcome chan struct{}
+	closed  chan struct{}
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	once    sync.Once
+}
+
+func newClient(s *Server, conn net.Conn) *Client {
+	return &Client{s: s, conn: conn, chans: make(map[string]bool),
+		notifyc: make(chan struct{}), welcome: make(chan struct{}), closed: make(chan struct{})}
+}
+
+// Nick is the bot's nick.
+func (c *Client) Nick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nick
+}
+
+// Prefix is the bot's nick!user@host.
+func (c *Client) Prefix() *irc.Prefix {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &irc.Prefix{Name: c.nick, User: c.user, Host: ServerName}
+}
+
+// UserPrefix is the prefix the server gives a scripted user.
+func UserPrefix(nick string) *irc.Prefix {
+	return &irc.Prefix{Name: nick, User: nick, Host: ServerName}
+}
+
+// Send writes raw lines to the bot.
+func (c *Client) Send(lines ...string) error {
+	for _, l := range lines {
+		msg := irc.ParseMessage(l)
+		if msg == nil {
+			continue
+		}
+		if err := c.write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Privmsg has user nick say text to target.
+func (c *Client) Privmsg(nick, target, text string) error {
+	return c.write(&irc.Message{Prefix: UserPrefix(nick), Command: irc.PRIVMSG, Params: []string{target, text}})
+}
+
+// Join has user nick join a channel the bot is on.
+func (c *Client) Join(nick, ch string) error {
+	c.s.AddUser(ch, nick)
+	return c.write(&irc.Message{Prefix: UserPrefix(nick), Command: irc.JOIN, Params: []string{ch}})
+}
+
+// Part has user nick leave a channel.
+func (c *Client) Part(nick, ch, msg string) error {
+	c.s.RemoveUser(ch, nick)
+	return c.write(&irc.Message{Prefix: UserPrefix(nick), Command: irc.PART, Params: []string{ch, msg}})
+}
+
+// Welcomed waits for the bot to register.
+func (c *Client) Welcomed(t testing.TB) {
+	t.Helper()
+	select {
+	case <-c.welcome:
+	case <-c.closed:
+		t.Fatal("bot hung up before registering")
+	case <-time.After(Timeout):
+		t.Fatal("bot did not register")
+	}
+}
+
+// Ping sends a PING and waits for its PONG, skipping what the bot sent
+// before it.
+func (c *Client) Ping(t testing.TB) {
+	t.Helper()
+	tok := time.Now().Format("150405.000000000")
+	if err := c.write(&irc.Message{Command: irc.PING, Params: []string{tok}}); err != nil {
+		t.Fatal(err)
+	}
+	c.Expect(t, irc.PONG, tok)
+}
+
+// Expect waits for the bot to send a cmd message starting with params,
+// skipping anything else it sent first.
+func (c *Client) Expect(t testing.TB, cmd string, params ...string) *irc.Message {
+	t.Helper()
+	deadline := time.After(Timeout)
+	for {
+		c.mu.Lock()
+		for c.next < len(c.msgs) {
+			msg := c.msgs[c.next]
+			c.next++
+			if matches(msg, cmd, params) {
+				c.mu.Unlock()
+				return msg
+			}
+		}
+		notifyc := c.notifyc
+		c.mu.Unlock()
+		select {
+		case <-notifyc:
+		case <-c.closed:
+			t.Fatalf("bot hung up before sending %s %q", cmd, params)
+		case <-deadline:
+			t.Fatalf("bot did not send %s %q", cmd, params)
+		}
+	}
+}
+
+func matches(msg *irc.Message, cmd string, params []string) bool {
+	if msg.Command != cmd || len(msg.Params) < len(params) {
+		return false
+	}
+	for i, p := range params {
+		if msg.Params[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// Sent returns everything the bot has sent.
+func (c *Client) Sent() []*irc.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*irc.Message(nil), c.msgs...)
+}
+
+// Closed is closed once the bot's connection ends.
+func (c *Client) Closed() <-chan struct{} { return c.closed }
+
+// Close hangs up on the bot.
+func (c *Client) Close() { c.conn.Close() }
+
+func (c *Client) write(msg *irc.Message) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(Timeout))
+	_, err := c.conn.Write([]byte(msg.String() + "\r\n"))
+	return err
+}
+
+func (c *Client) reply(code string, params ...string) {
+	c.write(&irc.Message{Prefix: &irc.Prefix{Name: ServerName}, Command: code,
+		Params: append([]string{c.Nick()}, params...)})
+}
+
+func (c *Client) serve() {
+	defer func() {
+		c.conn.Close()
+		c.once.Do(func() { close(c.closed) })
+	}()
+	dec := irc.NewDecoder(c.conn)
+	for {
+		msg, err := dec.Decode()
+		if err != nil {
+			return
+		}
+		if msg == nil {
+			continue
+		}
+		c.mu.Lock()
+		c.msgs = append(c.msgs, msg)
+		close(c.notifyc)
+		c.notifyc = make(chan struct{})
+		c.mu.Unlock()
+		if !c.handle(msg) {
+			return
+		}
+	}
+}
+
+// handle answers a message from the bot, returning false on QUIT.
+func (c *Client) handle(msg *irc.Message) bool {
+	switch msg.Command {
+	case irc.NICK:
+		if len(msg.Params) == 0 {
+			break
+		}
+		old := c.Prefix()
+		c.mu.Lock()
+		c.nick = msg.Params[0]
+		c.mu.Unlock()
+		if c.registered() {
+			c.write(&irc.Message{Prefix: old, Command: irc.NICK, Params: msg.Params[:1]})
+		}
+		c.register()
+	case irc.USER:
+		if len(msg.Params) == 0 {
+			break
+		}
+		c.mu.Lock()
+		c.user = msg.Params[0]
+		c.mu.Unlock()
+		c.register()
+	case irc.PING:
+		c.write(&irc.Message{Prefix: &irc.Prefix{Name: ServerName}, Command: irc.PONG,
+			Params: append([]string{ServerName}, msg.Params...)})
+	case irc.JOIN:
+		if len(msg.Params) == 0 {
+			break
+		}
+		for _, ch := range strings.Split(msg.Params[0], ",") {
+			c.mu.Lock()
+			c.chans[strings.ToLower(ch)] = true
+			c.mu.Unlock()
+			c.write(&irc.Message{Prefix: c.Prefix(), Command: irc.JOIN, Params: []string{ch}})
+			c.names(ch)
+		}
+	case irc.PART:
+		if len(msg.Params) == 0 {
+			break
+		}
+		for _, ch := range strings.Split(msg.Params[0], ",") {
+			c.mu.Lock()
+			delete(c.chans, strings.ToLower(ch))
+			c.mu.Unlock()
+			c.write(&irc.Message{Prefix: c.Prefix(), Command: irc.PART, Params: []string{ch}})
+		}
+	case irc.NAMES:
+		if len(msg.Params) > 0 {
+			c.names(msg.Params[0])
+		}
+	case irc.QUIT:
+		c.write(&irc.Message{Command: "ERROR", Params: []string{"Closing link"}})
+		return false
+	}
+	return true
+}
+
+func (c *Client) register() {
+	c.mu.Lock()
+	ready := c.nick != "" && c.user != ""
+	c.mu.Unlock()
+	if !ready {
+		return
+	}
+	if c.registered() {
+		return
+	}
+	c.reply(irc.RPL_WELCOME, "Welcome to "+ServerName+" "+c.Prefix().String())
+	close(c.welcome)
+}
+
+func (c *Client) registered() bool {
+	select {
+	case <-c.welcome:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Client) names(ch string) {
+	c.mu.Lock()
+	joined := c.chans[strings.ToLower(ch)]
+	c.mu.Unlock()
+	ns := c.s.names(ch)
+	if joined {
+		ns = append([]string{c.Nick()}, ns...)
+	}
+	c.reply(irc.RPL_NAMREPLY, "=", ch, strings.Join(ns, " "))
+	c.reply(irc.RPL_ENDOFNAMES, ch, "End of /NAMES list.")
+}
@@ -0,0 +1,68 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/url"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// jsonMsg is a message on a line-JSON transport, one object per line:
+//
+//	{"Prefix":"alice!a@example.com","Command":"PRIVMSG","Params":["#sitbot","hi"]}
+type jsonMsg struct {
+	Prefix  string `json:",omitempty"`
+	Command string
+	Params  []string `json:",omitempty"`
+}
+
+// jsonTransport carries IRC messages as lines of JSON so bridges to other
+// chat networks need no IRC parser. The bridge plays the server: it
+// answers the bot's registration with a 001 welcome and relays PRIVMSGs.
+type jsonTransport struct {
+	conn net.Conn
+	dec  *json.Decoder
+	enc  *json.Encoder
+}
+
+// NewJSONTransport speaks line-JSON over conn, for either end.
+func NewJSONTransport(conn net.Conn) Transport {
+	return &jsonTransport{conn: conn, dec: json.NewDecoder(conn), enc: json.NewEncoder(conn)}
+}
+
+func (t *jsonTransport) Decode() (*irc.Message, error) {
+	var jm jsonMsg
+	if err := t.dec.Decode(&jm); err != nil {
+		return nil, err
+	}
+	if jm.Command == "" {
+		return nil, nil
+	}
+	msg := &irc.Message{Command: jm.Command, Params: jm.Params}
+	if jm.Prefix != "" {
+		msg.Prefix = irc.ParsePrefix(jm.Prefix)
+	}
+	return msg, nil
+}
+
+func (t *jsonTransport) Encode(msg *irc.Message) error {
+	jm := jsonMsg{Command: msg.Command, Params: msg.Params}
+	if msg.Prefix != nil {
+		jm.Prefix = msg.Prefix.String()
+	}
+	return t.enc.Encode(&jm)
+}
+
+func (t *jsonTransport) Close() error         { return t.conn.Close() }
+func (t *jsonTransport) LocalAddr() net.Addr  { return t.conn.LocalAddr() }
+func (t *jsonTransport) RemoteAddr() net.Addr { return t.conn.RemoteAddr() }
+
+func dialJSONL(ctx context.Context, p *Profile, u *url.URL) (Transport, error) {
+	conn, err := p.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewJSONTransport(conn), nil
+}
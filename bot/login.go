@@ -1,6 +1,9 @@
 package bot
 
 import (
+	"strings"
+	"sync"
+
 	"gopkg.in/sorcix/irc.v2"
 )
 
@@ -9,6 +12,10 @@ type Login struct {
 	Netpfx   *irc.Prefix
 	tasks    *Tasks
 	welcomec chan struct{}
+	// nick is the bot's nick on the server, which differs from the
+	// profile's while it uses a fallback nick.
+	nick string
+	mu   sync.Mutex
 }
 
 func NewLogin(p *ProfileLogin, t *Tasks) *Login {
@@ -17,6 +24,13 @@ func NewLogin(p *ProfileLogin, t *Tasks) *Login {
 
 func (l *Login) Welcome() <-chan struct{} { return l.welcomec }
 
+// CurrentNick is the bot's nick on the server, or "" before registering.
+func (l *Login) CurrentNick() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nick
+}
+
 func (l *Login) Run() error {
 	n := 0
 	if l.Pass == "" {
@@ -41,11 +55,37 @@ func (l *Login) Run() error {
 func (l *Login) Process(msg irc.Message) error {
 	switch msg.Command {
 	case irc.RPL_WELCOME:
+		if len(msg.Params) > 0 {
+			l.mu.Lock()
+			l.nick = msg.Params[0]
+			l.mu.Unlock()
+		}
 		oldPfx := l.Netpfx
 		l.Netpfx = msg.Prefix
 		if oldPfx == nil {
 			close(l.welcomec)
 		}
+		// Welcome text usually ends with the bot's full nick!user@host.
+		if n := len(msg.Params); n > 0 {
+			ws := strings.Fields(msg.Params[n-1])
+			if len(ws) > 0 && strings.Contains(ws[len(ws)-1], "!") {
+				l.tasks.fmtr.SetPrefix(irc.ParsePrefix(ws[len(ws)-1]))
+			}
+		}
+	case irc.NICK:
+		l.mu.Lock()
+		self := msg.Prefix != nil && len(msg.Params) > 0 && strings.EqualFold(msg.Prefix.Name, l.nick)
+		if self {
+			l.nick = msg.Params[0]
+		}
+		l.mu.Unlock()
+		if self {
+			l.tasks.fmtr.SetPrefix(&irc.Prefix{Name: msg.Params[0], User: msg.Prefix.User, Host: msg.Prefix.Host})
+		}
+	case irc.JOIN:
+		if msg.Prefix != nil && strings.EqualFold(msg.Prefix.Name, l.CurrentNick()) && msg.Prefix.Host != "" {
+			l.tasks.fmtr.SetPrefix(msg.Prefix)
+		}
 	case irc.PING:
 		l.tasks.Run("ping", "PING", func(t *Task) error {
 			return t.Write(irc.Message{Command: irc.PONG, Params: msg.Params})
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"sync"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// loopback is one end of an in-memory Transport pair.
+type loopback struct {
+	readc  <-chan *irc.Message
+	writec chan<- *irc.Message
+	// closec is shared by both ends; closing either closes the pair.
+	closec chan struct{}
+	once   *sync.Once
+}
+
+// LoopbackPair returns two in-memory Transports, each decoding what the
+// other encodes.
+func LoopbackPair() (Transport, Transport) {
+	a2b, b2a := make(chan *irc.Message, 16), make(chan *irc.Message, 16)
+	closec, once := make(chan struct{}), &sync.Once{}
+	return &loopback{b2a, a2b, closec, once}, &loopback{a2b, b2a, closec, once}
+}
+
+func (l *loopback) Decode() (*irc.Message, error) {
+	// Deliver anything sent before the close.
+	select {
+	case msg := <-l.readc:
+		return msg, nil
+	default:
+	}
+	select {
+	case msg := <-l.readc:
+		return msg, nil
+	case <-l.closec:
+		return nil, io.EOF
+	}
+}
+
+func (l *loopback) Encode(msg *irc.Message) error {
+	m := *msg
+	m.Params = append([]string(nil), msg.Params...)
+	select {
+	case l.writec <- &m:
+		return nil
+	case <-l.closec:
+		return io.ErrClosedPipe
+	}
+}
+
+func (l *loopback) Close() error {
+	l.once.Do(func() { close(l.closec) })
+	return nil
+}
+
+// LoopbackListener accepts bots dialing "loop://<name>", for tests.
+type LoopbackListener struct {
+	name   string
+	connc  chan Transport
+	closec chan struct{}
+	once   sync.Once
+}
+
+var loopbacks = struct {
+	m  map[string]*LoopbackListener
+	mu sync.Mutex
+}{m: make(map[string]*LoopbackListener)}
+
+func ListenLoopback(name string) (*LoopbackListener, error) {
+	loopbacks.mu.Lock()
+	defer loopbacks.mu.Unlock()
+	if loopbacks.m[name] != nil {
+		return nil, fmt.Errorf("loopback %q in use", name)
+	}
+	l := &LoopbackListener{name: name, connc: make(chan Transport), closec: make(chan struct{})}
+	loopbacks.m[name] = l
+	return l, nil
+}
+
+// Accept returns the server end of the next bot to dial the listener.
+func (l *LoopbackListener) Accept(ctx context.Context) (Transport, error) {
+	select {
+	case tr := <-l.connc:
+		return tr, nil
+	case <-l.closec:
+		return nil, io.EOF
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *LoopbackListener) Close() error {
+	l.once.Do(func() {
+		loopbacks.mu.Lock()
+		delete(loopbacks.m, l.name)
+		loopbacks.mu.Unlock()
+		close(l.closec)
+	})
+	return nil
+}
+
+func dialLoopback(ctx context.Context, p *Profile, u *url.URL) (Transport, error) {
+	loopbacks.mu.Lock()
+	l := loopbacks.m[u.Host]
+	loopbacks.mu.Unlock()
+	if l == nil {
+		return nil, fmt.Errorf("no loopback listening on %q", u.Host)
+	}
+	c, s := LoopbackPair()
+	select {
+	case l.connc <- s:
+		return c, nil
+	case <-l.closec:
+		err := fmt.Errorf("loopback %q closed", u.Host)
+		c.Close()
+		return nil, err
+	case <-ctx.Done():
+		c.Close()
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,48 @@
+package bot
+
+// ircFold lower cases s by rfc1459 rules, where []\~ are the upper case
+// of {}|^.
+func ircFold(s string) []rune {
+	rs := []rune(s)
+	for i, r := range rs {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			rs[i] = r + 'a' - 'A'
+		case r == '[':
+			rs[i] = '{'
+		case r == ']':
+			rs[i] = '}'
+		case r == '\\':
+			rs[i] = '|'
+		case r == '~':
+			rs[i] = '^'
+		}
+	}
+	return rs
+}
+
+// MatchMask reports whether s matches an IRC mask like "*!*@host", where
+// * matches any run of characters and ? matches one, ignoring case.
+func MatchMask(mask, s string) bool {
+	m, str := ircFold(mask), ircFold(s)
+	// On a mismatch, backtrack to let the last * take one more character.
+	mi, si, star, starSi := 0, 0, -1, 0
+	for si < len(str) {
+		switch {
+		case mi < len(m) && m[mi] == '*':
+			star, starSi = mi, si
+			mi++
+		case mi < len(m) && (m[mi] == '?' || m[mi] == str[si]):
+			mi, si = mi+1, si+1
+		case star >= 0:
+			starSi++
+			mi, si = star+1, starSi
+		default:
+			return false
+		}
+	}
+	for mi < len(m) && m[mi] == '*' {
+		mi++
+	}
+	return mi == len(m)
+}
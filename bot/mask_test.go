@@ -0,0 +1,27 @@
+package bot
+
+import "testing"
+
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		mask, s string
+		ok      bool
+	}{
+		{"alice!*@*", "alice!a@user/alice", true},
+		{"*!*@user/*", "bob!b@user/bob/bot", true},
+		{"[x]!*@*", "{X}!x@h", true},
+		{"a\\b!*@*", "A|B!x@h", true},
+		{"a~!*@*", "A^!x@h", true},
+		{"?ob!*@h", "bob!b@h", true},
+		{"*a*a*", "banana", true},
+		{"*", "", true},
+		{"alice!*@*", "alicex!a@h", false},
+		{"?", "", false},
+		{"*@h", "bob!b@hx", false},
+	}
+	for _, tt := range tests {
+		if ok := MatchMask(tt.mask, tt.s); ok != tt.ok {
+			t.Errorf("MatchMask(%q, %q) = %v", tt.mask, tt.s, ok)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package bot
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// taskBuckets are the upper bounds in seconds of the task duration histogram.
+var taskBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(taskBuckets))
+	}
+	for i, b := range taskBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+type scriptExit struct {
+	script string
+	code   int
+}
+
+// taskStats accumulates finished task metrics under Tasks.mu.
+type taskStats struct {
+	durs  map[string]*histogram
+	fails map[string]uint64
+	exits map[scriptExit]uint64
+	// limitNs is the time tasks spent waiting on the task rate limit.
+	limitNs uint64
+}
+
+func (ts *taskStats) record(t *Task) {
+	if ts.durs == nil {
+		ts.durs = make(map[string]*histogram)
+		ts.fails = make(map[string]uint64)
+		ts.exits = make(map[scriptExit]uint64)
+	}
+	script := t.Script()
+	h := ts.durs[script]
+	if h == nil {
+		h = &histogram{}
+		ts.durs[script] = h
+	}
+	h.observe(t.Wall().Seconds())
+	if t.Err != "" || t.KillReason != "" || t.ExitCode != 0 {
+		ts.fails[script]++
+	}
+	if script != "" {
+		ts.exits[scriptExit{script, t.ExitCode}]++
+	}
+}
+
+// botMetrics is a snapshot of a bot's metrics.
+type botMetrics struct {
+	id                 string
+	connects           uint64
+	rxCmds, txCmds     map[string]uint64
+	rxBytes, txBytes   uint64
+	sendWait, taskWait time.Duration
+	running            int
+	total              uint64
+	durs               map[string]histogram
+	fails              map[string]uint64
+	exits              map[scriptExit]uint64
+	hits               map[patternKey]uint64
+	clients            int64
+	channels, users    int
+}
+
+func (b *Bot) metrics(id string) *botMetrics {
+	m := &botMetrics{
+		id:       id,
+		rxCmds:   b.mc.RxCmds(),
+		txCmds:   b.mc.TxCmds(),
+		rxBytes:  b.mc.RxBytes(),
+		txBytes:  b.mc.TxBytes(),
+		sendWait: b.mc.LimitWait(),
+		clients:  b.Clients(),
+		durs:     make(map[string]histogram),
+		fails:    make(map[string]uint64),
+		exits:    make(map[scriptExit]uint64),
+		hits:     make(map[patternKey]uint64),
+	}
+	t := b.Tasks
+	t.mu.RLock()
+	m.running, m.total = len(t.Tasks), uint64(t.tid)
+	for k, h := range t.stats.durs {
+		hh := *h
+		hh.counts = append([]uint64{}, h.counts...)
+		m.durs[k] = hh
+	}
+	for k, v := range t.stats.fails {
+		m.fails[k] = v
+	}
+	for k, v := range t.stats.exits {
+		m.exits[k] = v
+	}
+	t.mu.RUnlock()
+	m.taskWait = time.Duration(atomic.LoadUint64(&t.stats.limitNs))
+	d := b.dispatcher
+	d.mu.RLock()
+	for k, v := range d.hits {
+		m.hits[k] = v
+	}
+	d.mu.RUnlock()
+	b.State.RLock()
+	m.channels, m.users = len(b.State.Channels), len(b.State.Users)
+	b.State.RUnlock()
+	return m
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type metricWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (mw *metricWriter) family(name, typ, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes a value with labels given as name, value pairs.
+func (mw *metricWriter) sample(name string, v float64, labels ...string) {
+	var ls []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		ls = append(ls, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+	}
+	if len(ls) > 0 {
+		name += "{" + strings.Join(ls, ",") + "}"
+	}
+	mw.printf("%s %s\n", name, strconv.FormatFloat(v, 'g', -1, 64))
+}
+
+func (mw *metricWriter) printf(format string, args ...interface{}) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	ks := make([]string, 0, len(m))
+	for k := range m {
+		ks = append(ks, k)
+	}
+	sort.Strings(ks)
+	return ks
+}
+
+// WriteMetrics writes the gang's metrics in the Prometheus text format.
+func (g *Gang) WriteMetrics(w io.Writer) error {
+	g.mu.RLock()
+	var ms []*botMetrics
+	for id, b := range g.Bots {
+		m := b.metrics(id)
+		m.connects = g.connects[id]
+		ms = append(ms, m)
+	}
+	launches, errs := len(g.Launches), len(g.Errors)
+	g.mu.RUnlock()
+	sort.Slice(ms, func(i, j int) bool { return ms[i].id < ms[j].id })
+
+	mw := &metricWriter{w: w}
+	mw.family("sitbot_bots", "gauge", "Connected bots.")
+	mw.sample("sitbot_bots", float64(len(ms)))
+	mw.family("sitbot_launches", "gauge", "Bots connecting or failed to connect.")
+	mw.sample("sitbot_launches", float64(launches))
+	mw.family("sitbot_config_errors", "gauge", "Profile sources with errors.")
+	mw.sample("sitbot_config_errors", float64(errs))
+
+	perBot := func(name, typ, help string, f func(m *botMetrics)) {
+		mw.family(name, typ, help)
+		for _, m := range ms {
+			f(m)
+		}
+	}
+	perBot("sitbot_connects_total", "counter", "Successful launches; bots do not reconnect, so more than one means the profile was relaunched.", func(m *botMetrics) {
+		mw.sample("sitbot_connects_total", float64(m.connects), "bot", m.id)
+	})
+	perBot("sitbot_messages_received_total", "counter", "IRC messages received by command.", func(m *botMetrics) {
+		for _, c := range sortedKeys(m.rxCmds) {
+			mw.sample("sitbot_messages_received_total", float64(m.rxCmds[c]), "bot", m.id, "command", c)
+		}
+	})
+	perBot("sitbot_messages_sent_total", "counter", "IRC messages sent by command.", func(m *botMetrics) {
+		for _, c := range sortedKeys(m.txCmds) {
+			mw.sample("sitbot_messages_sent_total", float64(m.txCmds[c]), "bot", m.id, "command", c)
+		}
+	})
+	perBot("sitbot_received_bytes_total", "counter", "IRC bytes received.", func(m *botMetrics) {
+		mw.sample("sitbot_received_bytes_total", float64(m.rxBytes), "bot", m.id)
+	})
+	perBot("sitbot_sent_bytes_total", "counter", "IRC bytes sent.", func(m *botMetrics) {
+		mw.sample("sitbot_sent_bytes_total", float64(m.txBytes), "bot", m.id)
+	})
+	perBot("sitbot_rate_limit_wait_seconds_total", "counter", "Time spent waiting on rate limits.", func(m *botMetrics) {
+		mw.sample("sitbot_rate_limit_wait_seconds_total", m.sendWait.Seconds(), "bot", m.id, "limiter", "send")
+		mw.sample("sitbot_rate_limit_wait_seconds_total", m.taskWait.Seconds(), "bot", m.id, "limiter", "task")
+	})
+	perBot("sitbot_tasks_running", "gauge", "Running tasks.", func(m *botMetrics) {
+		mw.sample("sitbot_tasks_running", float64(m.running), "bot", m.id)
+	})
+	perBot("sitbot_tasks_total", "counter", "Started tasks.", func(m *botMetrics) {
+		mw.sample("sitbot_tasks_total", float64(m.total), "bot", m.id)
+	})
+	perBot("sitbot_task_duration_seconds", "histogram", "Wall time of finished tasks by script.", func(m *botMetrics) {
+		for _, s := range sortedKeys(m.durs) {
+			h := m.durs[s]
+			for i, b := range taskBuckets {
+				le := strconv.FormatFloat(b, 'g', -1, 64)
+				mw.sample("sitbot_task_duration_seconds_bucket", float64(h.counts[i]), "bot", m.id, "script", s, "le", le)
+			}
+			mw.sample("sitbot_task_duration_seconds_bucket", float64(h.count), "bot", m.id, "script", s, "le", "+Inf")
+			mw.sample("sitbot_task_duration_seconds_sum", h.sum, "bot", m.id, "script", s)
+			mw.sample("sitbot_task_duration_seconds_count", float64(h.count), "bot", m.id, "script", s)
+		}
+	})
+	perBot("sitbot_task_failures_total", "counter", "Tasks that errored, were killed, or exited nonzero.", func(m *botMetrics) {
+		for _, s := range sortedKeys(m.fails) {
+			mw.sample("sitbot_task_failures_total", float64(m.fails[s]), "bot", m.id, "script", s)
+		}
+	})
+	perBot("sitbot_task_exits_total", "counter", "Script exit codes.", func(m *botMetrics) {
+		exits := make([]scriptExit, 0, len(m.exits))
+		for k := range m.exits {
+			exits = append(exits, k)
+		}
+		sort.Slice(exits, func(i, j int) bool {
+			if exits[i].script != exits[j].script {
+				return exits[i].script < exits[j].script
+			}
+			return exits[i].code < exits[j].code
+		})
+		for _, e := range exits {
+			mw.sample("sitbot_task_exits_total", float64(m.exits[e]), "bot", m.id, "script", e.script, "code", strconv.Itoa(e.code))
+		}
+	})
+	perBot("sitbot_pattern_matches_total", "counter", "Messages matched by each pattern's Match.", func(m *botMetrics) {
+		keys := make([]patternKey, 0, len(m.hits))
+		for k := range m.hits {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			if keys[i].raw != keys[j].raw {
+				return !keys[i].raw
+			}
+			return keys[i].match < keys[j].match
+		})
+		for _, k := range keys {
+			list := "Patterns"
+			if k.raw {
+				list = "PatternsRaw"
+			}
+			mw.sample("sitbot_pattern_matches_total", float64(m.hits[k]), "bot", m.id, "list", list, "match", k.match)
+		}
+	})
+	perBot("sitbot_bouncer_clients", "gauge", "Connected bouncer clients.", func(m *botMetrics) {
+		mw.sample("sitbot_bouncer_clients", float64(m.clients), "bot", m.id)
+	})
+	perBot("sitbot_state_channels", "gauge", "Channels tracked by the bot's state.", func(m *botMetrics) {
+		mw.sample("sitbot_state_channels", float64(m.channels), "bot", m.id)
+	})
+	perBot("sitbot_state_users", "gauge", "Users tracked by the bot's state.", func(m *botMetrics) {
+		mw.sample("sitbot_state_users", float64(m.users), "bot", m.id)
+	})
+	return mw.err
+}
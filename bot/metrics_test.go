@@ -0,0 +1,61 @@
+package bot
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	fakeSandbox(t)
+	s := irctest.NewServer(t)
+	g := NewGang()
+	defer g.Shutdown(0, "bye")
+	p := Profile{Id: "m", ProfileLogin: ProfileLogin{ServerURL: s.URL(), Nick: "mb"}, Chans: []string{"#m"}, RateMs: 1,
+		Patterns: []Pattern{{Match: "^!echo (.*)", Template: "echo $1"}}}
+	if err := g.Post(p); err != nil {
+		t.Fatal(err)
+	}
+	c := s.Client(t)
+	c.Welcomed(t)
+	c.Expect(t, irc.JOIN, "#m")
+	waitFor(t, "bot online", func() bool { return g.Lookup("m") != nil })
+	c.Privmsg("alice", "#m", "!echo hi")
+	c.Expect(t, irc.PRIVMSG, "#m", "echo ran with hi for alice")
+	// Reordering patterns keeps their counts.
+	p.Patterns = append([]Pattern{{Match: "^!other", Template: "other"}}, p.Patterns...)
+	if err := g.Post(p); err != nil {
+		t.Fatal(err)
+	}
+	c.Privmsg("alice", "#m", "!echo again")
+	c.Expect(t, irc.PRIVMSG, "#m", "echo ran with again for alice")
+	waitFor(t, "tasks to finish", func() bool { return g.Lookup("m").Tasks.idle() })
+
+	var buf bytes.Buffer
+	if err := g.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE sitbot_connects_total counter\n",
+		`sitbot_connects_total{bot="m"} 1` + "\n",
+		`sitbot_pattern_matches_total{bot="m",list="Patterns",match="^!echo (.*)"} 2` + "\n",
+		`sitbot_task_duration_seconds_count{bot="m",script="echo"} 2` + "\n",
+		`sitbot_task_exits_total{bot="m",script="echo",code="0"} 2` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q", want)
+		}
+	}
+	sample := regexp.MustCompile(`^[a-z_]+(\{([a-z]+="([^"\\]|\\.)*",?)+\})? [-+0-9.e]+$`)
+	for _, l := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if !strings.HasPrefix(l, "# HELP ") && !strings.HasPrefix(l, "# TYPE ") && !sample.MatchString(l) {
+			t.Errorf("bad line %q", l)
+		}
+	}
+}
@@ -4,12 +4,33 @@ import (
 	"regexp"
 )
 
+// Pattern types for CTCP messages; see Pattern.Type.
+const (
+	PatternAction = "action"
+	PatternCTCP   = "ctcp"
+)
+
 type Pattern struct {
 	Match    string
 	Template string
+	// Type is the kind of message the pattern matches: "" for ordinary
+	// text, "action" for the text of CTCP ACTIONs, or "ctcp" for CTCP
+	// queries the bot does not answer itself, as "TYPE args".
+	Type string `json:",omitempty"`
+	// SessionMs routes later messages from the same sender and target to
+	// the script's stdin until it exits or is idle for SessionMs.
+	SessionMs int `json:",omitempty"`
+	// Targets masks where the task's callbacks may send messages,
+	// replacing the default of only the triggering target.
+	Targets []string `json:",omitempty"`
+	// KillTasks lets the task's callbacks kill the bot's other tasks.
+	KillTasks bool `json:",omitempty"`
+	// Limits overrides the profile's limits for this pattern's tasks.
+	Limits *Limits `json:",omitempty"`
 }
 
 type PatternMatcher struct {
+	pats []Pattern
 	re   []*regexp.Regexp
 	tmpl [][]byte
 }
@@ -25,22 +46,73 @@ func NewPatternMatcher(pats []Pattern) (*PatternMatcher, error) {
 		re[i] = r
 		tmpl[i] = []byte(pats[i].Template)
 	}
-	return &PatternMatcher{re, tmpl}, nil
+	return &PatternMatcher{pats, re, tmpl}, nil
 }
 
 func (pm *PatternMatcher) Apply(txt string) string {
+	_, s := pm.Find(txt)
+	return s
+}
+
+// Match is a pattern matching some text.
+type Match struct {
+	Index   int
+	Pattern Pattern
+	// Template is the pattern's template expanded with the match.
+	Template string
+}
+
+// Find returns the first pattern matching txt and its expanded template.
+func (pm *PatternMatcher) Find(txt string) (*Pattern, string) {
+	if i, res := pm.find("", txt); i >= 0 {
+		return &pm.pats[i], res
+	}
+	return nil, ""
+}
+
+func (pm *PatternMatcher) find(typ, txt string) (int, string) {
 	if len(txt) == 0 {
-		return ""
+		return -1, ""
 	}
 	txtb := []byte(txt)
-	for i, re := range pm.re {
-		if si := re.FindAllSubmatchIndex(txtb, 1); len(si) != 0 {
-			res := []byte{}
-			for _, submatches := range si {
-				res = re.Expand(res, pm.tmpl[i], txtb, submatches)
-			}
-			return string(res)
+	for i := range pm.re {
+		if pm.pats[i].Type != typ {
+			continue
+		}
+		if res, ok := pm.expand(i, txtb); ok {
+			return i, res
 		}
 	}
-	return ""
+	return -1, ""
+}
+
+// Matches returns every pattern of type typ matching txt; Find only uses
+// the first.
+func (pm *PatternMatcher) Matches(typ, txt string) (ret []Match) {
+	if len(txt) == 0 {
+		return nil
+	}
+	txtb := []byte(txt)
+	for i := range pm.re {
+		if pm.pats[i].Type != typ {
+			continue
+		}
+		if res, ok := pm.expand(i, txtb); ok {
+			ret = append(ret, Match{i, pm.pats[i], res})
+		}
+	}
+	return ret
+}
+
+func (pm *PatternMatcher) expand(i int, txtb []byte) (string, bool) {
+	re := pm.re[i]
+	si := re.FindAllSubmatchIndex(txtb, 1)
+	if len(si) == 0 {
+		return "", false
+	}
+	res := []byte{}
+	for _, submatches := range si {
+		res = re.Expand(res, pm.tmpl[i], txtb, submatches)
+	}
+	return string(res), true
 }
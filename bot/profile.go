@@ -8,6 +8,8 @@ import (
 	"net/url"
 
 	"golang.org/x/net/proxy"
+
+	"github.com/chzchzchz/sitbot/bot/dcc"
 )
 
 const defaultRateMs = 1000
@@ -17,11 +19,73 @@ type Profile struct {
 	Chans     []string
 	RateMs    int
 	Verbosity int
+	// Charset transcodes script output to a legacy encoding (e.g., "iso-8859-1").
+	Charset string `json:",omitempty"`
 
 	// Id is the way to reference this bot.
 	Id          string
 	Patterns    []Pattern
 	PatternsRaw []Pattern
+
+	// Limits applies to every pattern-triggered task.
+	Limits Limits
+
+	Schedules []Schedule `json:",omitempty"`
+
+	// Owners are masks (see MatchMask) on the prefixes (nick!user@host) of
+	// users who may control the bot over IRC.
+	Owners []string `json:",omitempty"`
+	// ControlPrefix starts owner commands sent over IRC, like "!sitbot";
+	// it defaults to the bot's nick.
+	ControlPrefix string `json:",omitempty"`
+
+	// CTCP configures replies to CTCP queries.
+	CTCP *CTCPConfig `json:",omitempty"`
+
+	// DCC enables file transfers over DCC SEND.
+	DCC *dcc.Config `json:",omitempty"`
+
+	// Services identifies to NickServ and asks ChanServ for help joining.
+	Services *ServicesConfig `json:",omitempty"`
+
+	// Guards manage channels the bot has ops in.
+	Guards []ChanGuard `json:",omitempty"`
+
+	// Relays link the bot's channels with channels on other bots in the gang.
+	Relays []Relay `json:",omitempty"`
+}
+
+// Limits bounds a task's resources; zero fields are unlimited.
+type Limits struct {
+	WallMs       int `json:",omitempty"`
+	MaxLines     int `json:",omitempty"`
+	MaxLineBytes int `json:",omitempty"`
+	// MaxTasks bounds concurrent tasks sharing the same Group.
+	MaxTasks int    `json:",omitempty"`
+	Group    string `json:",omitempty"`
+}
+
+// Merge returns l with the nonzero fields of o overriding it.
+func (l Limits) Merge(o *Limits) Limits {
+	if o == nil {
+		return l
+	}
+	if o.WallMs != 0 {
+		l.WallMs = o.WallMs
+	}
+	if o.MaxLines != 0 {
+		l.MaxLines = o.MaxLines
+	}
+	if o.MaxLineBytes != 0 {
+		l.MaxLineBytes = o.MaxLineBytes
+	}
+	if o.MaxTasks != 0 {
+		l.MaxTasks = o.MaxTasks
+	}
+	if o.Group != "" {
+		l.Group = o.Group
+	}
+	return l
 }
 
 type ProfileLogin struct {
@@ -60,6 +124,11 @@ func UnmarshalProfile(b []byte) (*Profile, error) {
 	return &p, nil
 }
 
+// Validate checks the profile can be applied to a bot.
+func (p *Profile) Validate() error {
+	return ValidateProfiles([]*Profile{p}, ScriptDir).Err()
+}
+
 type ctxDialer struct {
 	ctx context.Context
 	fwd net.Dialer
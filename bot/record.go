@@ -0,0 +1,302 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// Record is a message a bot received from or sent to its server.
+type Record struct {
+	Time Time
+	// Out is set on messages the bot sent.
+	Out bool `json:",omitempty"`
+	Msg string
+}
+
+// Recorder is a Stage writing a bot's traffic to a file for replay.
+type Recorder struct {
+	f      *os.File
+	stopTx func()
+	mu     sync.Mutex
+}
+
+// OpenRecorder starts a recording of bot id in dir, named for the time it
+// starts.
+func OpenRecorder(dir, id string) (*Recorder, error) {
+	if !fileId(id) {
+		return nil, fmt.Errorf("bad recording id %q", id)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	name := id + "-" + time.Now().Format("20060102T150405") + ".jsonl"
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{f: f}, nil
+}
+
+// Name is the recording's file name.
+func (r *Recorder) Name() string { return r.f.Name() }
+
+func (r *Recorder) Process(msg irc.Message) error {
+	r.write(false, msg, time.Now())
+	return nil
+}
+
+// hookTx records every message sent over mc until Close.
+func (r *Recorder) hookTx(mc *MsgConn) {
+	mc.SetTxHook(func(msg irc.Message, t time.Time) { r.write(true, msg, t) })
+	r.stopTx = func() { mc.SetTxHook(nil) }
+}
+
+// secretCommands are services commands whose arguments carry passwords.
+var secretCommands = map[string]bool{
+	"IDENTIFY": true, "GHOST": true, "REGISTER": true,
+	"RECOVER": true, "REGAIN": true, "RELEASE": true,
+}
+
+// redact hides passwords in a message before it is recorded.
+func redact(msg irc.Message) irc.Message {
+	params := append([]string(nil), msg.Params...)
+	switch cmd := strings.ToUpper(msg.Command); {
+	case cmd == irc.PASS || cmd == irc.OPER || cmd == "AUTHENTICATE":
+		if len(params) > 0 {
+			params[len(params)-1] = "*"
+		}
+	case cmd == "NICKSERV" || cmd == "NS":
+		params = redactSecret(params, 0)
+	case cmd == irc.PRIVMSG || cmd == irc.NOTICE:
+		// Only private messages; channels are already public.
+		if len(params) > 1 && !strings.HasPrefix(params[0], "#") {
+			params = redactSecret(params, 1)
+		}
+	}
+	msg.Params = params
+	return msg
+}
+
+// redactSecret blanks the arguments of the command starting at params[i]
+// if it is a secret command.
+func redactSecret(params []string, i int) []string {
+	if len(params) <= i {
+		return params
+	}
+	word, _, _ := strings.Cut(strings.Join(params[i:], " "), " ")
+	if secretCommands[strings.ToUpper(word)] {
+		params = append(params[:i], word+" *")
+	}
+	return params
+}
+
+func (r *Recorder) write(out bool, msg irc.Message, t time.Time) {
+	msg = redact(msg)
+	b, err := json.Marshal(&Record{Time: Time(t), Out: out, Msg: msg.String()})
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, err := r.f.Write(append(b, '\n')); err != nil {
+		log.Printf("[record] failed writing %s (%v)", r.f.Name(), err)
+	}
+}
+
+func (r *Recorder) Close() error {
+	if r.stopTx != nil {
+		r.stopTx()
+	}
+	return r.f.Close()
+}
+
+// ReadRecords reads a recording.
+func ReadRecords(rd io.Reader) (recs []Record, err error) {
+	s := bufio.NewScanner(rd)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		var r Record
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		recs = append(recs, r)
+	}
+	return recs, s.Err()
+}
+
+// ReplayOptions controls a replay.
+type ReplayOptions struct {
+	// Speed scales the recording's pace; zero replays without waiting.
+	Speed float64
+	// Drain bounds waiting for the replay's tasks to finish.
+	Drain time.Duration
+	// Watch gets each message as it is replayed to the bot and each
+	// message the bot sends, if set.
+	Watch func(Record)
+}
+
+// replayWelcomeWait bounds waiting for the bot to register after the
+// recording is fed to it.
+const replayWelcomeWait = 5 * time.Second
+
+// replayQuiet is how often a finished replay checks for running tasks.
+const replayQuiet = 100 * time.Millisecond
+
+var replays uint64
+
+// Replay runs a bot for p against a fake server that feeds it the messages
+// it received in recs, capturing what it sends instead.
+func Replay(ctx context.Context, p Profile, recs []Record, o ReplayOptions) error {
+	name := fmt.Sprintf("replay-%d", atomic.AddUint64(&replays, 1))
+	l, err := ListenLoopback(name)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	// Nothing may reach the outside world.
+	p.ServerURL, p.ProxyURL, p.DCC = "loop://"+name, "", nil
+	if p.RateMs == 0 {
+		p.RateMs = defaultRateMs
+	}
+	var watchMu sync.Mutex
+	watch := func(r Record) {
+		if o.Watch != nil {
+			watchMu.Lock()
+			o.Watch(r)
+			watchMu.Unlock()
+		}
+	}
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type botErr struct {
+		b   *Bot
+		err error
+	}
+	botc := make(chan botErr, 1)
+	go func() {
+		b, err := NewBot(cctx, p)
+		botc <- botErr{b, err}
+	}()
+	srv, err := l.Accept(cctx)
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	// The bot's PONG to this marks the end of the replayed traffic.
+	const endTok = "sitbot-replay-end"
+	endc := make(chan struct{})
+	capturec := make(chan struct{})
+	go func() {
+		defer close(capturec)
+		for {
+			msg, err := srv.Decode()
+			if err != nil {
+				return
+			}
+			watch(Record{Time: Time(time.Now()), Out: true, Msg: msg.String()})
+			switch {
+			case msg.Command == irc.PONG && msg.Trailing() == endTok:
+				close(endc)
+			case msg.Command == irc.QUIT:
+				srv.Close()
+			}
+		}
+	}()
+
+	var b *Bot
+	defer func() {
+		if b != nil {
+			b.Close()
+		}
+	}()
+	waitBot := func() error {
+		select {
+		case be := <-botc:
+			b = be.b
+			return be.err
+		case <-time.After(replayWelcomeWait):
+			return fmt.Errorf("bot never registered; recordings start at connect")
+		case <-cctx.Done():
+			return cctx.Err()
+		}
+	}
+	start, t0 := time.Now(), time.Time{}
+	for _, r := range recs {
+		if r.Out {
+			continue
+		}
+		msg := irc.ParseMessage(r.Msg)
+		if msg == nil {
+			continue
+		}
+		if t0.IsZero() {
+			t0 = r.Time.T()
+		}
+		if o.Speed > 0 {
+			at := start.Add(time.Duration(float64(r.Time.T().Sub(t0)) / o.Speed))
+			select {
+			case <-time.After(time.Until(at)):
+			case <-cctx.Done():
+				return cctx.Err()
+			}
+		}
+		watch(Record{Time: Time(time.Now()), Msg: msg.String()})
+		if err := srv.Encode(msg); err != nil {
+			return err
+		}
+		if b == nil && msg.Command == irc.RPL_WELCOME {
+			// Replay the rest once the bot is up, as it was when recorded.
+			paused := time.Now()
+			if err := waitBot(); err != nil {
+				return err
+			}
+			start = start.Add(time.Since(paused))
+		}
+	}
+	if b == nil {
+		if err := waitBot(); err != nil {
+			return err
+		}
+	}
+	if err := srv.Encode(&irc.Message{Command: irc.PING, Params: []string{endTok}}); err != nil {
+		return err
+	}
+	deadline := time.After(o.Drain)
+	select {
+	case <-endc:
+	case <-deadline:
+	}
+	// The stages may still be dispatching the last messages, so wait for
+	// a quiet spell with no tasks before stopping new ones.
+	for quiet := 0; quiet < 2; {
+		select {
+		case <-time.After(replayQuiet):
+		case <-deadline:
+			quiet = 2
+			continue
+		}
+		if b.Tasks.idle() {
+			quiet++
+		} else {
+			quiet = 0
+		}
+	}
+	b.Shutdown(o.Drain, "replay done")
+	b = nil
+	srv.Close()
+	<-capturec
+	return nil
+}
@@ -0,0 +1,105 @@
+package bot
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+func TestRedact(t *testing.T) {
+	for _, tt := range []struct{ in, want string }{
+		{"PASS hunter2", "PASS *"},
+		{"PRIVMSG NickServ :IDENTIFY rb s3cret", "PRIVMSG NickServ :IDENTIFY *"},
+		{"PRIVMSG NickServ :ghost rb s3cret", "PRIVMSG NickServ :ghost *"},
+		{"NICKSERV IDENTIFY s3cret", "NICKSERV :IDENTIFY *"},
+		{"PRIVMSG #chan :identify yourself", "PRIVMSG #chan :identify yourself"},
+		{"PRIVMSG alice :hello there", "PRIVMSG alice :hello there"},
+	} {
+		msg := irc.ParseMessage(tt.in)
+		if got := redact(*msg); got.String() != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got.String(), tt.want)
+		}
+	}
+}
+
+func TestRecordReplay(t *testing.T) {
+	fakeSandbox(t)
+	s := irctest.NewServer(t)
+	p := Profile{Id: "r", ProfileLogin: ProfileLogin{ServerURL: s.URL(), Nick: "rb", Pass: "hunter2"}, Chans: []string{"#r"},
+		RateMs: 1, Patterns: []Pattern{{Match: "^!echo (.*)", Template: "echo $1"}},
+		Services: &ServicesConfig{Password: "s3cret"}}
+	botc := make(chan *Bot, 1)
+	go func() {
+		b, err := newBot(context.Background(), p, func(string) {}, nil, t.TempDir())
+		if err != nil {
+			t.Error(err)
+		}
+		botc <- b
+	}()
+	c := s.Client(t)
+	c.Welcomed(t)
+	b := <-botc
+	if b == nil {
+		t.FailNow()
+	}
+	c.Expect(t, irc.JOIN, "#r")
+	c.Privmsg("alice", "#r", "!echo recorded")
+	c.Expect(t, irc.PRIVMSG, "#r", "echo ran with recorded for alice")
+	waitFor(t, "identify", func() bool {
+		for _, msg := range c.Sent() {
+			if msg.Command == irc.PRIVMSG && msg.Params[0] == defaultNickServ {
+				return true
+			}
+		}
+		return false
+	})
+	name := b.Recorder.Name()
+	b.Close()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := ReadRecords(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var in, out int
+	for _, r := range recs {
+		if strings.Contains(r.Msg, "hunter2") || strings.Contains(r.Msg, "s3cret") {
+			t.Errorf("recorded password in %q", r.Msg)
+		}
+		if r.Out {
+			out++
+		} else {
+			in++
+		}
+	}
+	if in == 0 || out == 0 {
+		t.Fatalf("recorded %d in, %d out", in, out)
+	}
+
+	var sent []string
+	o := ReplayOptions{Drain: time.Second, Watch: func(r Record) {
+		if r.Out {
+			sent = append(sent, r.Msg)
+		}
+	}}
+	if err := Replay(context.Background(), p, recs, o); err != nil {
+		t.Fatal(err)
+	}
+	want := "PRIVMSG #r :echo ran with recorded for alice"
+	for _, l := range sent {
+		if l == want {
+			return
+		}
+	}
+	t.Fatalf("replay sent %q, want %q", sent, want)
+}
@@ -0,0 +1,346 @@
+package bot
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/ascii"
+)
+
+// relayQueueLen bounds the relayed messages waiting on a bot's rate limit.
+const relayQueueLen = 64
+
+// relayEvents are the events a Relay can mirror.
+var relayEvents = map[string]bool{"privmsg": true, "action": true, "join": true, "part": true, "nick": true}
+
+// relayColors are the mIRC colors for nicks, skipping white, black, and greys.
+var relayColors = []int{2, 3, 4, 5, 6, 7, 9, 10, 11, 12, 13}
+
+// Relay links a channel on the bot with a channel on another bot in the
+// same gang, mirroring messages as "<nick@net> text".
+type Relay struct {
+	Channel string
+	// Peer is the Id of the other bot.
+	Peer        string
+	PeerChannel string
+	// Net names the bot's network in relayed nicks, defaulting to its Id;
+	// PeerNet names the peer's.
+	Net     string `json:",omitempty"`
+	PeerNet string `json:",omitempty"`
+	// OneWay only mirrors Channel to PeerChannel.
+	OneWay bool `json:",omitempty"`
+	// Events picks from privmsg, action, join, part, and nick; empty
+	// mirrors all of them.
+	Events []string `json:",omitempty"`
+	// Ignore are masks on prefixes (nick!user@host) of users not relayed.
+	Ignore []string `json:",omitempty"`
+	// Skip drops messages with text matching this regular expression.
+	Skip string `json:",omitempty"`
+	// Color colors nicks from the mIRC palette.
+	Color bool `json:",omitempty"`
+}
+
+// relayRoute mirrors one bot's channel to another's.
+type relayRoute struct {
+	src, srcChan string
+	dst, dstChan string
+	net          string
+	events       map[string]bool
+	ignore       []string
+	skip         *regexp.Regexp
+	color        bool
+}
+
+// routes compiles the relay of bot id into its routes.
+func (rl *Relay) routes(id string) ([]*relayRoute, error) {
+	fwd := &relayRoute{src: id, srcChan: rl.Channel, dst: rl.Peer, dstChan: rl.PeerChannel,
+		net: rl.Net, ignore: rl.Ignore, color: rl.Color}
+	if fwd.net == "" {
+		fwd.net = id
+	}
+	if rl.Skip != "" {
+		re, err := regexp.Compile(rl.Skip)
+		if err != nil {
+			return nil, err
+		}
+		fwd.skip = re
+	}
+	if len(rl.Events) > 0 {
+		fwd.events = make(map[string]bool)
+		for _, ev := range rl.Events {
+			if !relayEvents[ev] {
+				return nil, fmt.Errorf("unknown event %q", ev)
+			}
+			fwd.events[ev] = true
+		}
+	}
+	if rl.OneWay {
+		return []*relayRoute{fwd}, nil
+	}
+	rev := *fwd
+	rev.src, rev.srcChan, rev.dst, rev.dstChan, rev.net = rl.Peer, rl.PeerChannel, id, rl.Channel, rl.PeerNet
+	if rev.net == "" {
+		rev.net = rl.Peer
+	}
+	return []*relayRoute{fwd, &rev}, nil
+}
+
+func (rt *relayRoute) allows(ev string, pfx *irc.Prefix, txt string) bool {
+	if rt.events != nil && !rt.events[ev] {
+		return false
+	}
+	for _, ig := range rt.ignore {
+		if MatchMask(ig, pfx.String()) {
+			return false
+		}
+	}
+	return rt.skip == nil || txt == "" || !rt.skip.MatchString(txt)
+}
+
+func (rt *relayRoute) who(nick string) string {
+	if !rt.color {
+		return nick + "@" + rt.net
+	}
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(nick)))
+	c, _ := ascii.MircColor(relayColors[h.Sum32()%uint32(len(relayColors))])
+	code := ascii.ColorPair{Foreground: c}.MircCode(ascii.NewPaletteMIRC())
+	return string(code) + nick + "\x03@" + rt.net
+}
+
+func (rt *relayRoute) format(ev, nick, txt string) string {
+	who := rt.who(nick)
+	switch ev {
+	case "action":
+		return "* " + who + " " + txt
+	case "join":
+		return "-> " + who + " joined " + rt.srcChan
+	case "part":
+		if txt != "" {
+			return "<- " + who + " left " + rt.srcChan + " (" + txt + ")"
+		}
+		return "<- " + who + " left " + rt.srcChan
+	case "nick":
+		return "-- " + who + " is now " + txt
+	}
+	return "<" + who + "> " + txt
+}
+
+type relayKey struct {
+	id, ch string
+}
+
+// relayTap reads a bot's messages for relaying and writes the messages
+// relayed to it.
+type relayTap struct {
+	outc chan irc.Message
+	stop func()
+}
+
+// relays mirrors channels between a gang's bots.
+type relays struct {
+	bots   map[string]*Bot
+	routes map[relayKey][]*relayRoute
+	taps   map[*Bot]*relayTap
+	mu     sync.Mutex
+}
+
+func newRelays() *relays {
+	return &relays{taps: make(map[*Bot]*relayTap)}
+}
+
+// set routes the relays of bots, tapping the bots on either end.
+func (r *relays) set(bots map[string]*Bot) {
+	ids := make([]string, 0, len(bots))
+	for id := range bots {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	routes := make(map[relayKey][]*relayRoute)
+	used := make(map[*Bot]string)
+	for _, id := range ids {
+		b := bots[id]
+		b.mu.RLock()
+		rls := b.Relays
+		b.mu.RUnlock()
+		for _, rl := range rls {
+			rts, err := rl.routes(id)
+			if err != nil {
+				log.Printf("[relay] bad relay on %s (%v)", id, err)
+				continue
+			}
+			for _, rt := range rts {
+				if bots[rt.src] == nil || bots[rt.dst] == nil {
+					continue
+				}
+				k := relayKey{rt.src, strings.ToLower(rt.srcChan)}
+				if !hasRoute(routes[k], rt) {
+					routes[k] = append(routes[k], rt)
+				}
+				used[bots[rt.src]], used[bots[rt.dst]] = rt.src, rt.dst
+			}
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.bots, r.routes = bots, routes
+	for b, t := range r.taps {
+		if _, ok := used[b]; !ok {
+			t.stop()
+			delete(r.taps, b)
+		}
+	}
+	for b, id := range used {
+		if r.taps[b] == nil {
+			r.taps[b] = r.tap(id, b)
+		}
+	}
+}
+
+// hasRoute reports whether a link declared by both bots is already routed.
+func hasRoute(rts []*relayRoute, rt *relayRoute) bool {
+	for _, o := range rts {
+		if o.dst == rt.dst && strings.EqualFold(o.dstChan, rt.dstChan) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *relays) tap(id string, b *Bot) *relayTap {
+	mc := b.mc
+	rc, dc := mc.NewReadChan()
+	stopc := make(chan struct{})
+	t := &relayTap{outc: make(chan irc.Message, relayQueueLen)}
+	var once sync.Once
+	t.stop = func() {
+		once.Do(func() {
+			close(stopc)
+			close(dc)
+			mc.DropReadChan(rc)
+		})
+	}
+	go func() {
+		for {
+			select {
+			case msg, ok := <-rc:
+				if !ok {
+					return
+				}
+				r.relay(id, b, msg)
+			case <-stopc:
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case msg := <-t.outc:
+				if err := b.Write(0, msg); err != nil {
+					return
+				}
+			case <-stopc:
+				return
+			case <-b.ctx.Done():
+				return
+			}
+		}
+	}()
+	return t
+}
+
+// relay mirrors a message from bot id to the channels linked with its channel.
+func (r *relays) relay(id string, b *Bot, msg irc.Message) {
+	if msg.Prefix == nil || len(msg.Params) == 0 {
+		return
+	}
+	nick := msg.Prefix.Name
+	var ev, txt string
+	chans := msg.Params[:1]
+	switch msg.Command {
+	case irc.PRIVMSG:
+		if len(msg.Params) < 2 {
+			return
+		}
+		ev, txt = "privmsg", msg.Params[1]
+		if typ, args, ok := ctcpSplit(txt); ok {
+			if typ != "ACTION" {
+				return
+			}
+			ev, txt = "action", args
+		}
+	case irc.JOIN:
+		ev = "join"
+	case irc.PART:
+		ev = "part"
+		if len(msg.Params) > 1 {
+			txt = msg.Params[1]
+		}
+	case irc.NICK:
+		// The state stage may or may not have renamed the user yet.
+		ev, txt = "nick", msg.Params[0]
+		chans = b.State.nickChannels(nick, txt)
+	default:
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.isBot(nick) {
+		// Never relay the gang's own bots, so links cannot loop.
+		return
+	}
+	for _, ch := range chans {
+		for _, rt := range r.routes[relayKey{id, strings.ToLower(ch)}] {
+			if !rt.allows(ev, msg.Prefix, txt) {
+				continue
+			}
+			dst := r.bots[rt.dst]
+			t := r.taps[dst]
+			if t == nil {
+				continue
+			}
+			f := dst.Tasks.fmtr
+			for _, l := range f.Split(rt.format(ev, nick, txt), f.Budget(irc.PRIVMSG, rt.dstChan)) {
+				select {
+				case t.outc <- irc.Message{Command: irc.PRIVMSG, Params: []string{rt.dstChan, l}}:
+				default:
+					log.Printf("[relay] %s is behind; dropping message to %s", rt.dst, rt.dstChan)
+				}
+			}
+		}
+	}
+}
+
+func (r *relays) isBot(nick string) bool {
+	for _, b := range r.bots {
+		b.mu.RLock()
+		bn := b.Nick
+		b.mu.RUnlock()
+		if strings.EqualFold(bn, nick) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripFormat removes mIRC formatting codes from s.
+func stripFormat(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if n := ctrlLen(s[i:]); n > 0 {
+			i += n
+			continue
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return sb.String()
+}
@@ -0,0 +1,77 @@
+package bot
+
+import (
+	"testing"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+func TestRelayRoutes(t *testing.T) {
+	rl := &Relay{Channel: "#a", Peer: "y", PeerChannel: "#b", Net: "efnet",
+		Events: []string{"privmsg", "part"}, Ignore: []string{"*!*@bots.example"}, Skip: "^!"}
+	rts, err := rl.routes("x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rts) != 2 || rts[0].dst != "y" || rts[1].dst != "x" || rts[1].net != "y" {
+		t.Fatalf("bad routes %+v", rts)
+	}
+	fwd, pfx := rts[0], irc.ParsePrefix("alice!a@home.example")
+	if l := fwd.format("privmsg", "alice", "hi"); l != "<alice@efnet> hi" {
+		t.Errorf("got %q", l)
+	}
+	if l := fwd.format("part", "alice", "bye"); l != "<- alice@efnet left #a (bye)" {
+		t.Errorf("got %q", l)
+	}
+	if !fwd.allows("privmsg", pfx, "hi") {
+		t.Errorf("expected privmsg relayed")
+	}
+	if fwd.allows("join", pfx, "") || fwd.allows("privmsg", pfx, "!cmd") {
+		t.Errorf("expected join and skipped text dropped")
+	}
+	if fwd.allows("privmsg", irc.ParsePrefix("bot!b@bots.example"), "hi") {
+		t.Errorf("expected ignored user dropped")
+	}
+	fwd.color = true
+	if l := stripFormat(fwd.format("privmsg", "alice", "hi")); l != "<alice@efnet> hi" {
+		t.Errorf("colored nick stripped to %q", l)
+	}
+	if _, err := (&Relay{Events: []string{"topic"}}).routes("x"); err == nil {
+		t.Errorf("expected bad event error")
+	}
+}
+
+func TestRelayGang(t *testing.T) {
+	sx, sy := irctest.NewServer(t), irctest.NewServer(t)
+	g := NewGang()
+	defer g.Shutdown(0, "bye")
+	px := Profile{Id: "x", ProfileLogin: ProfileLogin{ServerURL: sx.URL(), Nick: "xb"}, Chans: []string{"#a"}, RateMs: 1,
+		Relays: []Relay{{Channel: "#a", Peer: "y", PeerChannel: "#b"}}}
+	py := Profile{Id: "y", ProfileLogin: ProfileLogin{ServerURL: sy.URL(), Nick: "yb"}, Chans: []string{"#b"}, RateMs: 1}
+	for _, p := range []Profile{px, py} {
+		if err := g.Post(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cx, cy := sx.Client(t), sy.Client(t)
+	cx.Welcomed(t)
+	cy.Welcomed(t)
+	cx.Expect(t, irc.JOIN, "#a")
+	cy.Expect(t, irc.JOIN, "#b")
+	waitFor(t, "bots online", func() bool { return g.Lookup("x") != nil && g.Lookup("y") != nil })
+
+	// Text that looks relayed is still a user's message.
+	cx.Privmsg("alice", "#a", "<bob@elsewhere> hi")
+	cy.Expect(t, irc.PRIVMSG, "#b", "<alice@x> <bob@elsewhere> hi")
+	// The gang's own bots are never relayed back.
+	cy.Privmsg("yb", "#b", "<alice@x> loop")
+	cy.Privmsg("carol", "#b", "back")
+	cx.Expect(t, irc.PRIVMSG, "#a", "<carol@y> back")
+	for _, msg := range cx.Sent() {
+		if msg.Command == irc.PRIVMSG && msg.Params[1] == "<yb@y> <alice@x> loop" {
+			t.Errorf("relayed bot message %q", msg.Params[1])
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Schedule runs a script on a timer instead of in response to a message.
+type Schedule struct {
+	Name string
+	// Cron is a five field cron expression or alias such as "@hourly".
+	Cron string `json:",omitempty"`
+	// IntervalMs runs the script periodically if Cron is not set.
+	IntervalMs int `json:",omitempty"`
+	Template   string
+	// Target is the channel receiving the script's output.
+	Target string
+	Limits *Limits `json:",omitempty"`
+}
+
+type schedEntry struct {
+	Schedule
+	cron *Cron
+	next time.Time
+}
+
+// compileSchedules checks schedules and prepares them for a Scheduler.
+func compileSchedules(scheds []Schedule) ([]*schedEntry, error) {
+	var ret []*schedEntry
+	names := make(map[string]struct{})
+	for _, s := range scheds {
+		if _, ok := names[s.Name]; ok {
+			return nil, fmt.Errorf("schedule %q: duplicate name", s.Name)
+		}
+		names[s.Name] = struct{}{}
+		e := &schedEntry{Schedule: s}
+		if s.Cron != "" {
+			c, err := ParseCron(s.Cron)
+			if err != nil {
+				return nil, fmt.Errorf("schedule %q: %v", s.Name, err)
+			}
+			e.cron = c
+		} else if s.IntervalMs <= 0 {
+			return nil, fmt.Errorf("schedule %q: needs Cron or IntervalMs", s.Name)
+		}
+		if s.Template == "" || s.Target == "" {
+			return nil, fmt.Errorf("schedule %q: needs Template and Target", s.Name)
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+func (e *schedEntry) after(t time.Time) time.Time {
+	if e.cron != nil {
+		return e.cron.Next(t)
+	}
+	return t.Add(time.Duration(e.IntervalMs) * time.Millisecond)
+}
+
+// Scheduler fires a bot's schedules through its Tasks.
+type Scheduler struct {
+	d       *Dispatcher
+	ctx     context.Context
+	cancel  context.CancelFunc
+	entries []*schedEntry
+	wg      sync.WaitGroup
+	mu      sync.RWMutex
+	// setMu serializes replacing the schedules.
+	setMu sync.Mutex
+}
+
+func NewScheduler(ctx context.Context, d *Dispatcher) *Scheduler {
+	return &Scheduler{d: d, ctx: ctx, cancel: func() {}}
+}
+
+// ScheduleStatus is a schedule and when it fires next.
+type ScheduleStatus struct {
+	Schedule
+	Next Time
+}
+
+// Entries lists the schedules ordered by their next fire time.
+func (s *Scheduler) Entries() (ret []ScheduleStatus) {
+	s.mu.RLock()
+	for _, e := range s.entries {
+		ret = append(ret, ScheduleStatus{e.Schedule, Time(e.next)})
+	}
+	s.mu.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Next.T().Before(ret[j].Next.T()) })
+	return ret
+}
+
+func (s *Scheduler) MarshalJSON() ([]byte, error) { return json.Marshal(s.Entries()) }
+
+// set replaces the running schedules. Schedules keeping their name and
+// timing keep their next fire time.
+func (s *Scheduler) set(entries []*schedEntry) {
+	s.setMu.Lock()
+	defer s.setMu.Unlock()
+	s.stop()
+	if s.ctx.Err() != nil {
+		return
+	}
+	cctx, cancel := context.WithCancel(s.ctx)
+	s.mu.RLock()
+	old := make(map[string]*schedEntry, len(s.entries))
+	for _, e := range s.entries {
+		old[e.Name] = e
+	}
+	s.mu.RUnlock()
+	now := time.Now()
+	for _, e := range entries {
+		if o := old[e.Name]; o != nil && !o.next.IsZero() && o.Cron == e.Cron && o.IntervalMs == e.IntervalMs {
+			e.next = o.next
+		} else {
+			e.next = e.after(now)
+		}
+	}
+	s.mu.Lock()
+	s.cancel, s.entries = cancel, entries
+	s.mu.Unlock()
+	for _, e := range entries {
+		s.wg.Add(1)
+		go s.run(cctx, e)
+	}
+}
+
+func (s *Scheduler) stop() {
+	s.mu.RLock()
+	cancel := s.cancel
+	s.mu.RUnlock()
+	cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run(ctx context.Context, e *schedEntry) {
+	defer s.wg.Done()
+	for {
+		s.mu.RLock()
+		next := e.next
+		s.mu.RUnlock()
+		if next.IsZero() {
+			return
+		}
+		tm := time.NewTimer(time.Until(next))
+		select {
+		case <-tm.C:
+		case <-ctx.Done():
+			tm.Stop()
+			return
+		}
+		s.fire(e)
+		s.mu.Lock()
+		e.next = e.after(time.Now())
+		s.mu.Unlock()
+	}
+}
+
+func (s *Scheduler) fire(e *schedEntry) {
+	log.Printf("[schedule] %q running %q", e.Name, e.Template)
+	if err := s.d.RunScript(e.Name, e.Template, e.Target, e.Limits, "SITBOT_SCHEDULE="+e.Name); err != nil {
+		log.Printf("[schedule] could not run %q (%v)", e.Name, err)
+	}
+}
+
+func (s *Scheduler) Close() {
+	s.setMu.Lock()
+	defer s.setMu.Unlock()
+	s.stop()
+}
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSchedulerSetKeepsNext(t *testing.T) {
+	s := NewScheduler(context.Background(), nil)
+	defer s.Close()
+	set := func(scheds ...Schedule) map[string]time.Time {
+		entries, err := compileSchedules(scheds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.set(entries)
+		next := make(map[string]time.Time)
+		for _, e := range s.Entries() {
+			next[e.Name] = e.Next.T()
+		}
+		return next
+	}
+	hourly := Schedule{Name: "hourly", IntervalMs: 3600000, Template: "fortune", Target: "#t"}
+	daily := Schedule{Name: "daily", Cron: "@daily", Template: "news", Target: "#t"}
+	before := set(hourly, daily)
+	time.Sleep(10 * time.Millisecond)
+
+	// Editing the command keeps the timing; editing the timing restarts it.
+	hourly.Template = "fortune -s"
+	daily.Cron, daily.IntervalMs = "", 3600000
+	after := set(hourly, daily)
+	if !after["hourly"].Equal(before["hourly"]) {
+		t.Errorf("hourly moved from %v to %v", before["hourly"], after["hourly"])
+	}
+	if after["daily"].Equal(before["daily"]) {
+		t.Errorf("daily kept %v after its timing changed", before["daily"])
+	}
+}
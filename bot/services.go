@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+const (
+	defaultNickServ       = "NickServ"
+	defaultChanServ       = "ChanServ"
+	defaultIdentifyWaitMs = 10000
+	defaultRejoinMs       = 3000
+	defaultRejoins        = 3
+	// ghostWait gives NickServ time to free the nick before reclaiming it.
+	ghostWait = time.Second
+)
+
+// servicesIdentified matches NickServ notices confirming identification.
+var servicesIdentified = regexp.MustCompile(`(?i)you are now (identified|logged in)|password accepted|you are now recognized`)
+
+// servicesKey matches ChanServ's reply to GETKEY.
+var servicesKey = regexp.MustCompile(`(?i)(#\S+) key is:? (\S+)`)
+
+// ServicesConfig identifies the bot to NickServ and has ChanServ let it
+// into channels it is locked out of.
+type ServicesConfig struct {
+	// Account defaults to the bot's nick.
+	Account  string `json:",omitempty"`
+	Password string `json:",omitempty"`
+	NickServ string `json:",omitempty"`
+	ChanServ string `json:",omitempty"`
+	// WaitIdentify holds channel joins until NickServ confirms, for up to
+	// IdentifyWaitMs.
+	WaitIdentify   bool `json:",omitempty"`
+	IdentifyWaitMs int  `json:",omitempty"`
+	// Ghost takes a fallback nick when the bot's nick is in use, then
+	// has NickServ disconnect the holder and reclaims it.
+	Ghost bool `json:",omitempty"`
+	// Op are the channels to ask ChanServ for ops in on joining.
+	Op []string `json:",omitempty"`
+	// RejoinMs is how long to wait on ChanServ before joining again, up
+	// to Rejoins times.
+	RejoinMs int `json:",omitempty"`
+	Rejoins  int `json:",omitempty"`
+}
+
+// Services is the stage talking to NickServ and ChanServ.
+type Services struct {
+	b           *Bot
+	identifiedc chan struct{}
+	// taken is set when the bot's nick was in use at registration.
+	taken bool
+	// tries counts rejoins by channel; keys are from ChanServ.
+	tries map[string]int
+	keys  map[string]string
+	mu    sync.Mutex
+	once  sync.Once
+}
+
+func NewServices(b *Bot) *Services {
+	return &Services{b: b, identifiedc: make(chan struct{}), tries: make(map[string]int), keys: make(map[string]string)}
+}
+
+// config returns the profile's services configuration with defaults and
+// the bot's nick, or false if the profile has none.
+func (s *Services) config() (cfg ServicesConfig, nick string, ok bool) {
+	s.b.mu.RLock()
+	defer s.b.mu.RUnlock()
+	if s.b.Services == nil {
+		return cfg, s.b.Nick, false
+	}
+	cfg = *s.b.Services
+	if cfg.Account == "" {
+		cfg.Account = s.b.Nick
+	}
+	if cfg.NickServ == "" {
+		cfg.NickServ = defaultNickServ
+	}
+	if cfg.ChanServ == "" {
+		cfg.ChanServ = defaultChanServ
+	}
+	return cfg, s.b.Nick, true
+}
+
+// waitIdentified waits for NickServ to confirm identification, if the
+// profile asks to.
+func (s *Services) waitIdentified(ctx context.Context) {
+	cfg, _, ok := s.config()
+	if !ok || !cfg.WaitIdentify || cfg.Password == "" {
+		return
+	}
+	wait := time.Duration(orDefault(cfg.IdentifyWaitMs, defaultIdentifyWaitMs)) * time.Millisecond
+	select {
+	case <-s.identifiedc:
+	case <-time.After(wait):
+		log.Printf("[services] %s did not confirm identification after %v", cfg.NickServ, wait)
+	case <-ctx.Done():
+	}
+}
+
+func (s *Services) identified() { s.once.Do(func() { close(s.identifiedc) }) }
+
+func (s *Services) Process(msg irc.Message) error {
+	cfg, nick, ok := s.config()
+	if !ok {
+		return nil
+	}
+	switch msg.Command {
+	case irc.ERR_NICKNAMEINUSE:
+		// Only fall back before registering; afterwards the server keeps
+		// the old nick.
+		if !cfg.Ghost || !s.registering() || len(msg.Params) < 2 {
+			break
+		}
+		s.mu.Lock()
+		s.taken = true
+		s.mu.Unlock()
+		alt := msg.Params[1] + "_"
+		log.Printf("[services] %s is in use; trying %s", msg.Params[1], alt)
+		s.b.mc.WriteMsg(irc.Message{Command: irc.NICK, Params: []string{alt}})
+	case irc.RPL_WELCOME:
+		s.welcome(cfg, nick)
+	case irc.RPL_LOGGEDIN:
+		s.identified()
+	case irc.NOTICE:
+		if msg.Prefix == nil || len(msg.Params) < 2 {
+			break
+		}
+		txt := stripFormat(msg.Params[1])
+		if strings.EqualFold(msg.Prefix.Name, cfg.NickServ) && servicesIdentified.MatchString(txt) {
+			s.identified()
+		} else if strings.EqualFold(msg.Prefix.Name, cfg.ChanServ) {
+			// Only take keys for channels the bot asked to be let into.
+			if m := servicesKey.FindStringSubmatch(txt); m != nil && s.pending(m[1]) {
+				s.mu.Lock()
+				s.keys[strings.ToLower(m[1])] = m[2]
+				s.mu.Unlock()
+				s.join(m[1])
+			}
+		}
+	case irc.ERR_BANNEDFROMCHAN, irc.ERR_INVITEONLYCHAN, irc.ERR_BADCHANNELKEY:
+		if len(msg.Params) > 1 {
+			s.lockedOut(cfg, msg.Command, msg.Params[1])
+		}
+	case irc.INVITE:
+		if len(msg.Params) > 1 && strings.EqualFold(msg.Params[0], nick) && s.pending(msg.Params[1]) {
+			s.join(msg.Params[1])
+		}
+	case irc.JOIN:
+		if msg.Prefix == nil || !strings.EqualFold(msg.Prefix.Name, nick) {
+			break
+		}
+		ch := msg.Params[0]
+		s.mu.Lock()
+		delete(s.tries, strings.ToLower(ch))
+		s.mu.Unlock()
+		for _, op := range cfg.Op {
+			if strings.EqualFold(op, ch) {
+				s.ask(cfg, "OP", ch, nick)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Services) registering() bool {
+	select {
+	case <-s.b.Login.Welcome():
+		return false
+	default:
+		return true
+	}
+}
+
+// welcome reclaims the bot's nick if it was taken and identifies.
+func (s *Services) welcome(cfg ServicesConfig, nick string) {
+	s.mu.Lock()
+	taken := s.taken
+	s.taken = false
+	s.mu.Unlock()
+	if cfg.Password == "" {
+		return
+	}
+	// Keep the password out of the task's command.
+	s.b.Tasks.Run("services", "identify "+cfg.Account, func(t *Task) error {
+		t.mu.Lock()
+		t.Target = cfg.NickServ
+		t.mu.Unlock()
+		if taken {
+			ghost := irc.Message{Command: irc.PRIVMSG, Params: []string{cfg.NickServ, "GHOST " + nick + " " + cfg.Password}}
+			if err := t.Write(ghost); err != nil {
+				return err
+			}
+			select {
+			case <-time.After(ghostWait):
+			case <-t.ctx.Done():
+				return t.ctx.Err()
+			}
+			if err := t.Write(irc.Message{Command: irc.NICK, Params: []string{nick}}); err != nil {
+				return err
+			}
+		}
+		return t.Write(irc.Message{Command: irc.PRIVMSG,
+			Params: []string{cfg.NickServ, "IDENTIFY " + cfg.Account + " " + cfg.Password}})
+	})
+}
+
+// lockedOut asks ChanServ to let the bot into a channel it failed to join
+// and tries again.
+func (s *Services) lockedOut(cfg ServicesConfig, code, ch string) {
+	k := strings.ToLower(ch)
+	s.mu.Lock()
+	s.tries[k]++
+	n := s.tries[k]
+	s.mu.Unlock()
+	if n > orDefault(cfg.Rejoins, defaultRejoins) {
+		log.Printf("[services] giving up on joining %s", ch)
+		return
+	}
+	_, nick, _ := s.config()
+	switch code {
+	case irc.ERR_BANNEDFROMCHAN:
+		s.ask(cfg, "UNBAN", ch)
+	case irc.ERR_INVITEONLYCHAN:
+		s.ask(cfg, "INVITE", ch, nick)
+	case irc.ERR_BADCHANNELKEY:
+		// The key comes back in a notice, which joins.
+		s.ask(cfg, "GETKEY", ch)
+		return
+	}
+	wait := time.Duration(orDefault(cfg.RejoinMs, defaultRejoinMs)) * time.Millisecond
+	time.AfterFunc(wait, func() {
+		if s.b.ctx.Err() == nil && s.pending(ch) {
+			s.join(ch)
+		}
+	})
+}
+
+// pending reports whether the bot is still trying to get into ch.
+func (s *Services) pending(ch string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tries[strings.ToLower(ch)] > 0
+}
+
+func (s *Services) ask(cfg ServicesConfig, args ...string) {
+	s.b.Tasks.Run("services", strings.ToLower(args[0])+" "+args[1], func(t *Task) error {
+		t.mu.Lock()
+		t.Target = cfg.ChanServ
+		t.mu.Unlock()
+		return t.Write(irc.Message{Command: irc.PRIVMSG, Params: []string{cfg.ChanServ, strings.Join(args, " ")}})
+	})
+}
+
+func (s *Services) join(ch string) {
+	params := []string{ch}
+	s.mu.Lock()
+	if key := s.keys[strings.ToLower(ch)]; key != "" {
+		params = append(params, key)
+	}
+	s.mu.Unlock()
+	s.b.Tasks.Run("services", "join "+ch, func(t *Task) error {
+		return t.Write(irc.Message{Command: irc.JOIN, Params: params})
+	})
+}
@@ -0,0 +1,56 @@
+package bot
+
+import (
+	"context"
+	"testing"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+func TestServices(t *testing.T) {
+	s := irctest.NewServer(t)
+	p := Profile{ProfileLogin: ProfileLogin{Nick: "sb", ServerURL: s.URL()}, RateMs: 1, Chans: []string{"#s"},
+		Services: &ServicesConfig{Password: "pw", WaitIdentify: true, Op: []string{"#s"}, RejoinMs: 10}}
+	botc := make(chan *Bot, 1)
+	go func() {
+		b, err := NewBot(context.Background(), p)
+		if err != nil {
+			t.Error(err)
+		}
+		botc <- b
+	}()
+	c := s.Client(t)
+	c.Welcomed(t)
+	c.Expect(t, irc.PRIVMSG, "NickServ", "IDENTIFY sb pw")
+	c.Send(":NickServ!s@services NOTICE sb :You are now identified for \x02sb\x02.")
+	c.Expect(t, irc.JOIN, "#s")
+	b := <-botc
+	if b == nil {
+		t.FailNow()
+	}
+	t.Cleanup(b.Close)
+	c.Expect(t, irc.PRIVMSG, "ChanServ", "OP #s sb")
+
+	c.Send(":irctest 474 sb #ban :Cannot join channel (+b)")
+	c.Expect(t, irc.PRIVMSG, "ChanServ", "UNBAN #ban")
+	c.Expect(t, irc.JOIN, "#ban")
+	c.Send(":irctest 473 sb #inv :Cannot join channel (+i)")
+	c.Expect(t, irc.PRIVMSG, "ChanServ", "INVITE #inv sb")
+	c.Send(":ChanServ!s@services INVITE sb #inv")
+	c.Expect(t, irc.JOIN, "#inv")
+	// Keys for channels the bot never asked about are ignored.
+	c.Send(":ChanServ!s@services NOTICE sb :Channel \x02#trap\x02 key is: \x02bait\x02")
+	c.Send(":irctest 475 sb #key :Cannot join channel (+k)")
+	c.Expect(t, irc.PRIVMSG, "ChanServ", "GETKEY #key")
+	c.Send(":ChanServ!s@services NOTICE sb :Channel \x02#key\x02 key is: \x02sesame\x02")
+	c.Expect(t, irc.JOIN, "#key", "sesame")
+	waitFor(t, "tasks to finish", b.Tasks.idle)
+	c.Ping(t)
+	for _, msg := range c.Sent() {
+		if msg.Command == irc.JOIN && msg.Params[0] == "#trap" {
+			t.Errorf("joined #trap on an unsolicited key")
+		}
+	}
+}
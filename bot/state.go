@@ -11,6 +11,8 @@ import (
 type State struct {
 	Channels map[string]*room
 	Users    map[string]*user
+	// whox is set when the server supports WHO with fields (WHOX).
+	whox bool
 	sync.RWMutex
 }
 
@@ -33,6 +35,42 @@ func (s *State) Process(msg irc.Message) error {
 		r := s.lookupRoom(room)
 		r.Joined = true
 		s.addModeUser(r, sender)
+		if u := s.Users[sender]; u != nil {
+			u.User, u.Host = msg.Prefix.User, msg.Prefix.Host
+		}
+	case irc.MODE:
+		if len(msg.Params) > 1 {
+			s.mode(msg.Params[0], msg.Params[1], msg.Params[2:])
+		}
+	case rplWhoisAccount:
+		if len(msg.Params) > 2 {
+			if u := s.Users[msg.Params[1]]; u != nil {
+				u.Account = msg.Params[2]
+			}
+		}
+	case irc.RPL_ISUPPORT:
+		for _, p := range msg.Params[1:] {
+			if p == "WHOX" {
+				s.whox = true
+			}
+		}
+	case irc.RPL_WHOREPLY:
+		// me channel user host server nick flags :hops realname
+		if len(msg.Params) > 5 {
+			if u := s.Users[msg.Params[5]]; u != nil {
+				u.User, u.Host = msg.Params[2], msg.Params[3]
+			}
+		}
+	case rplWhoSpcRpl:
+		// me token user host nick account, as asked for by whoxFields.
+		if len(msg.Params) > 5 && msg.Params[1] == whoxToken {
+			if u := s.Users[msg.Params[4]]; u != nil {
+				u.User, u.Host = msg.Params[2], msg.Params[3]
+				if u.Account = msg.Params[5]; u.Account == "0" {
+					u.Account = ""
+				}
+			}
+		}
 	case irc.RPL_TOPIC:
 		room, topic := msg.Params[1], msg.Params[2]
 		s.lookupRoom(room).Topic = topic
@@ -100,19 +138,88 @@ func (s *State) lookupRoom(rn string) *room {
 	return r
 }
 
+// rplWhoisAccount is the WHOIS reply with a user's services account.
+const rplWhoisAccount = "330"
+
+const (
+	// rplWhoSpcRpl is a WHOX reply.
+	rplWhoSpcRpl = "354"
+	// whoxToken tags the bot's WHOX queries; whoxFields asks for the
+	// user, host, nick, and account.
+	whoxToken  = "152"
+	whoxFields = "%tuhna," + whoxToken
+)
+
+// who returns a WHO query for channel ch, using WHOX for accounts if the
+// server supports it.
+func (s *State) who(ch string) irc.Message {
+	s.RLock()
+	defer s.RUnlock()
+	if s.whox {
+		return irc.Message{Command: irc.WHO, Params: []string{ch, whoxFields}}
+	}
+	return irc.Message{Command: irc.WHO, Params: []string{ch}}
+}
+
 var umodes = []string{"~", "@", "+", "=", "!", "&", "%"}
 
+// prefixModes are the channel modes shown as nick prefixes.
+var prefixModes = map[byte]string{'q': "~", 'a': "&", 'o': "@", 'h': "%", 'v': "+"}
+
+// mode applies a channel MODE change to its users' prefixes.
+func (s *State) mode(ch, modes string, args []string) {
+	r, ok := s.Channels[ch]
+	on := true
+	for i := 0; i < len(modes); i++ {
+		c := modes[i]
+		switch {
+		case c == '+' || c == '-':
+			on = c == '+'
+			continue
+		case prefixModes[c] != "":
+		case strings.IndexByte("beIkfj", c) >= 0 || (c == 'l' && on):
+			// Modes with arguments that are not about users.
+			if len(args) > 0 {
+				args = args[1:]
+			}
+			continue
+		default:
+			continue
+		}
+		if len(args) == 0 {
+			return
+		}
+		nick := args[0]
+		args = args[1:]
+		if !ok {
+			continue
+		}
+		if ru, ok := r.Users[nick]; ok {
+			ru.Mode = setUmode(ru.Mode, prefixModes[c], on)
+			r.Users[nick] = ru
+		}
+	}
+}
+
+// setUmode adds or removes a prefix from a user's prefixes.
+func setUmode(cur, um string, on bool) (ret string) {
+	for _, m := range umodes {
+		if m == um && on || m != um && strings.Contains(cur, m) {
+			ret += m
+		}
+	}
+	return ret
+}
+
 func (s *State) addModeUser(r *room, u string) {
 	if len(u) == 0 {
 		return
 	}
+	// Servers with multi-prefix list every prefix a user has.
 	umode, unick := "", u
-	for _, um := range umodes {
-		if um == u[:1] {
-			umode = um
-			unick = u[1:]
-			break
-		}
+	for len(unick) > 0 && strings.Contains(strings.Join(umodes, ""), unick[:1]) {
+		umode = setUmode(umode, unick[:1], true)
+		unick = unick[1:]
 	}
 	if len(unick) <= 1 {
 		return
@@ -141,5 +248,67 @@ type user struct {
 	Nick     string
 	User     string `json:",omitempty"`
 	Host     string `json:",omitempty"`
+	Account  string `json:",omitempty"`
 	Channels map[string]struct{}
 }
+
+// HasOp reports whether nick is an operator of channel ch.
+func (s *State) HasOp(ch, nick string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	r, ok := s.Channels[ch]
+	if !ok {
+		return false
+	}
+	ru, ok := r.Users[nick]
+	return ok && strings.ContainsAny(ru.Mode, "~&@")
+}
+
+// channelNicks returns the lower case nicks on channel ch.
+func (s *State) channelNicks(ch string) map[string]bool {
+	s.RLock()
+	defer s.RUnlock()
+	nicks := make(map[string]bool)
+	if r, ok := s.Channels[ch]; ok {
+		for n := range r.Users {
+			nicks[strings.ToLower(n)] = true
+		}
+	}
+	return nicks
+}
+
+// prefix returns a user's nick!user@host, as far as it is known.
+func (s *State) prefix(nick string) *irc.Prefix {
+	s.RLock()
+	defer s.RUnlock()
+	pfx := &irc.Prefix{Name: nick}
+	if u := s.Users[nick]; u != nil {
+		pfx.User, pfx.Host = u.User, u.Host
+	}
+	return pfx
+}
+
+// account returns a user's services account, if known.
+func (s *State) account(nick string) string {
+	s.RLock()
+	defer s.RUnlock()
+	if u := s.Users[nick]; u != nil {
+		return u.Account
+	}
+	return ""
+}
+
+// nickChannels returns the channels with a user going by any of nicks.
+func (s *State) nickChannels(nicks ...string) (chans []string) {
+	s.RLock()
+	defer s.RUnlock()
+	for name, r := range s.Channels {
+		for _, n := range nicks {
+			if _, ok := r.Users[n]; ok {
+				chans = append(chans, name)
+				break
+			}
+		}
+	}
+	return chans
+}
@@ -2,6 +2,10 @@ package bot
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -14,22 +18,123 @@ import (
 	"gopkg.in/sorcix/irc.v2"
 )
 
+// historyLen is the number of finished tasks kept by Tasks.
+const historyLen = 32
+
+// ScriptDir holds the scripts run by tasks.
+const ScriptDir = "scripts"
+
+var ErrTooManyTasks = errors.New("too many tasks")
+var ErrDraining = errors.New("tasks draining")
+
 type TaskId uint64
 type Task struct {
 	Name    string
 	Start   Time
 	Command string
-	tid     TaskId
-	lines   uint32
+	// From is the prefix of the sender triggering the task, Rule is the
+	// pattern or schedule starting it, and Target receives its output.
+	From   string `json:",omitempty"`
+	Rule   string `json:",omitempty"`
+	Target string `json:",omitempty"`
+	// Progress tracks the task's file transfer, if any.
+	Progress *Progress `json:",omitempty"`
+
+	// Set once the task finishes.
+	End        Time
+	CPU        time.Duration
+	ExitCode   int
+	KillReason string `json:",omitempty"`
+	Err        string `json:",omitempty"`
+
+	tid    TaskId
+	lines  uint32
+	limits Limits
+	// internal tasks are the bot's own protocol replies; they never count
+	// against MaxTasks and keep running while draining.
+	internal bool
+	// token authenticates the task's callbacks to write to targets.
+	token   string
+	targets []string
+	// killAny lets the token kill other tasks.
+	killAny bool
+	script  string
 	mc      *MsgConn
-	ctx     context.Context
-	cancel  context.CancelFunc
-	donec   <-chan struct{}
+	fmtr    *Formatter
+	// directive handles control lines from the task's output.
+	directive DirectiveFunc
+	ctx       context.Context
+	cancel    context.CancelFunc
+	donec     <-chan struct{}
+
+	// stdinc feeds a session's lines to the command's stdin until closed;
+	// without it, stdin is /dev/null.
+	stdinc <-chan string
+	mu     sync.Mutex
 }
 type TaskFunc func(*Task) error
 
+// Progress is a transfer's position out of its size in bytes.
+type Progress struct {
+	Size int64
+	pos  int64
+}
+
+func (p *Progress) Pos() int64     { return atomic.LoadInt64(&p.pos) }
+func (p *Progress) Set(pos int64)  { atomic.StoreInt64(&p.pos, pos) }
+func (p *Progress) String() string { return fmt.Sprintf("%d/%d", p.Pos(), p.Size) }
+
+func (p *Progress) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ Pos, Size int64 }{p.Pos(), p.Size})
+}
+
+// DirectiveFunc handles a control line l from a task writing to tgt.
+type DirectiveFunc func(t *Task, tgt, l string) error
+
 func (t *Task) Lines() uint32 { return atomic.LoadUint32(&t.lines) }
 
+func (t *Task) Wall() time.Duration {
+	return t.End.T().Sub(t.Start.T()).Round(time.Millisecond)
+}
+
+// TaskStatus is a copy of a task's fields taken under its lock.
+type TaskStatus struct {
+	Name    string
+	Start   Time
+	Command string
+	From    string `json:",omitempty"`
+	Rule    string `json:",omitempty"`
+	Target  string `json:",omitempty"`
+	Lines   uint32
+	// Progress tracks the task's file transfer, if any.
+	Progress *Progress `json:",omitempty"`
+
+	End        Time
+	CPU        time.Duration
+	ExitCode   int
+	KillReason string `json:",omitempty"`
+	Err        string `json:",omitempty"`
+}
+
+func (s TaskStatus) Wall() time.Duration {
+	return s.End.T().Sub(s.Start.T()).Round(time.Millisecond)
+}
+
+// Status snapshots the task so it can be read while the task runs.
+func (t *Task) Status() TaskStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return TaskStatus{
+		Name: t.Name, Start: t.Start, Command: t.Command,
+		From: t.From, Rule: t.Rule, Target: t.Target,
+		Lines: t.Lines(), Progress: t.Progress,
+		End: t.End, CPU: t.CPU, ExitCode: t.ExitCode,
+		KillReason: t.KillReason, Err: t.Err,
+	}
+}
+
+func (t *Task) MarshalJSON() ([]byte, error) { return json.Marshal(t.Status()) }
+
 func (t *Task) Write(msg irc.Message) error {
 	if err := t.mc.WriteMsg(msg); err != nil {
 		return err
@@ -38,27 +143,148 @@ func (t *Task) Write(msg irc.Message) error {
 	return nil
 }
 
+// feedStdin writes lines from stdinc to w, closing w when stdinc closes.
+// Lines are discarded once a write fails, so the session never blocks.
+func feedStdin(w io.WriteCloser, stdinc <-chan string) {
+	defer w.Close()
+	var err error
+	for l := range stdinc {
+		if err == nil {
+			_, err = io.WriteString(w, l+"\n")
+		}
+	}
+}
+
+// Script is the name of the script run by the task, if any.
+func (t *Task) Script() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.script
+}
+
+// Allows reports whether the task's token may send msg on behalf of tid.
+// Targets are matched as masks ignoring case, so "#*" allows any channel.
+func (t *Task) Allows(tid TaskId, msg irc.Message) bool {
+	t.mu.Lock()
+	targets, killAny := t.targets, t.killAny
+	t.mu.Unlock()
+	tgtok := func(tgt string) bool {
+		for _, pat := range targets {
+			if MatchMask(pat, tgt) {
+				return true
+			}
+		}
+		return false
+	}
+	if msg.Command == irc.KILL && len(msg.Params) == 0 {
+		return tid == t.tid || killAny
+	}
+	if tid != 0 && tid != t.tid {
+		return false
+	}
+	switch msg.Command {
+	case irc.PRIVMSG, irc.NOTICE, irc.KICK, irc.MODE, irc.TOPIC, irc.JOIN, irc.PART:
+		return len(msg.Params) > 0 && tgtok(msg.Params[0])
+	case irc.INVITE:
+		return len(msg.Params) > 1 && tgtok(msg.Params[1])
+	}
+	return false
+}
+
+// kill cancels the task, recording the first reason given.
+func (t *Task) kill(reason string) {
+	t.mu.Lock()
+	if t.KillReason == "" {
+		t.KillReason = reason
+	}
+	t.mu.Unlock()
+	t.cancel()
+}
+
+func (t *Task) finish(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.End = Time(time.Now())
+	if err != nil {
+		t.Err = err.Error()
+	}
+	if t.KillReason == "" {
+		switch t.ctx.Err() {
+		case context.DeadlineExceeded:
+			t.KillReason = "timeout"
+		case context.Canceled:
+			t.KillReason = "canceled"
+		}
+	}
+	t.cancel()
+}
+
+// sandboxArgs returns the script named by a command and the arguments
+// running it in the sandbox.
+func sandboxArgs(cmdtxt string) (string, []string) {
+	toks := strings.Split(cmdtxt, " ")
+	cmdname := strings.Replace(toks[0], "/", "_", -1)
+	return cmdname, append([]string{cmdname}, toks[1:]...)
+}
+
+func taskEnv(tid TaskId, token, script string) []string {
+	return []string{fmt.Sprintf("SITBOT_TID=%d", tid), "SITBOT_TOKEN=" + token, "SITBOT_SCRIPT=" + script}
+}
+
 func (t *Task) PipeCmd(cmdtxt, tgt string, env []string) (err error) {
 	cctx, cancel := context.WithCancel(t.ctx)
-	tidenv := fmt.Sprintf("SITBOT_TID=%d", t.tid)
-	toks := strings.Split(cmdtxt, " ")
-	cmdname, cmdargs := strings.Replace(toks[0], "/", "_", -1), toks[1:]
-	env = append(env, tidenv)
-	cmd, err := NewCmd(cctx, "scripts/sandbox", append([]string{cmdname}, cmdargs...), env)
+	cmdname, args := sandboxArgs(cmdtxt)
+	env = append(env, taskEnv(t.tid, t.token, cmdname)...)
+	t.mu.Lock()
+	t.script, t.Command, t.Target = cmdname, cmdtxt, tgt
+	if len(t.targets) == 0 {
+		t.targets = []string{tgt}
+	}
+	t.mu.Unlock()
+	newCmd := NewCmd
+	if t.stdinc != nil {
+		newCmd = NewSessionCmd
+	}
+	cmd, err := newCmd(cctx, ScriptDir+"/sandbox", args, env)
 	if err != nil {
 		cancel()
 		return err
 	}
+	if t.stdinc != nil {
+		go feedStdin(cmd.Stdin(), t.stdinc)
+	}
 	defer func() {
 		cancel()
 		if err2 := cmd.Close(); err == nil {
 			err = err2
 		}
+		if ps := cmd.ProcessState(); ps != nil {
+			t.mu.Lock()
+			t.ExitCode, t.CPU = ps.ExitCode(), ps.UserTime()+ps.SystemTime()
+			t.mu.Unlock()
+		}
 	}()
+	n := t.fmtr.Budget(irc.PRIVMSG, tgt)
+	if m := t.limits.MaxLineBytes; m > 0 && m < n {
+		n = m
+	}
 	for l := range cmd.Lines() {
-		out := irc.Message{Command: irc.PRIVMSG, Params: []string{tgt, l}}
-		if err := t.Write(out); err != nil {
-			return err
+		// Lines beginning with the token are directives for the bot.
+		if d, ok := strings.CutPrefix(l, t.token+" "); ok && t.directive != nil {
+			if err := t.directive(t, tgt, strings.TrimRight(d, "\r\n")); err != nil {
+				log.Printf("[task] bad directive from %q (%v)", t.Command, err)
+			}
+			continue
+		}
+		for _, l := range t.fmtr.Split(l, n) {
+			if t.limits.MaxLines > 0 && int(t.Lines()) >= t.limits.MaxLines {
+				t.kill("lines")
+				return nil
+			}
+			out := irc.Message{Command: irc.PRIVMSG, Params: []string{tgt, l}}
+			if err := t.Write(out); err != nil {
+				return err
+			}
 		}
 		if err := cctx.Err(); err != nil {
 			return err
@@ -72,28 +298,104 @@ type Tasks struct {
 	cancel  context.CancelFunc
 	limiter *rate.Limiter
 	Tasks   map[TaskId]*Task
+	tokens  map[string]*Task
+	// History holds the most recently finished tasks, oldest first.
+	History []*Task
 	tid     TaskId
 	mc      *MsgConn
-	mu      sync.RWMutex
-	wg      sync.WaitGroup
+	fmtr    *Formatter
+	// directive handles control lines from the tasks' output.
+	directive DirectiveFunc
+	// draining rejects new script tasks while running ones finish; the
+	// bot's internal tasks still run.
+	draining bool
+	stats    taskStats
+	// audit records finished tasks, if set.
+	audit *Audit
+	mu    sync.RWMutex
+	wg    sync.WaitGroup
 }
 
-func NewTasks(ctx context.Context, l *rate.Limiter, mc *MsgConn) *Tasks {
+func NewTasks(ctx context.Context, l *rate.Limiter, mc *MsgConn, f *Formatter) *Tasks {
 	cctx, cancel := context.WithCancel(ctx)
 	return &Tasks{
 		ctx:     cctx,
 		cancel:  cancel,
 		limiter: l,
 		mc:      mc,
+		fmtr:    f,
 		Tasks:   make(map[TaskId]*Task),
+		tokens:  make(map[string]*Task),
+	}
+}
+
+// Running snapshots the running tasks.
+func (t *Tasks) Running() map[TaskId]TaskStatus {
+	t.mu.RLock()
+	tasks := make([]*Task, 0, len(t.Tasks))
+	for _, tt := range t.Tasks {
+		tasks = append(tasks, tt)
+	}
+	t.mu.RUnlock()
+	ret := make(map[TaskId]TaskStatus, len(tasks))
+	for _, tt := range tasks {
+		ret[tt.tid] = tt.Status()
+	}
+	return ret
+}
+
+// Finished snapshots the task history, oldest first.
+func (t *Tasks) Finished() []TaskStatus {
+	t.mu.RLock()
+	tasks := append([]*Task(nil), t.History...)
+	t.mu.RUnlock()
+	ret := make([]TaskStatus, len(tasks))
+	for i, tt := range tasks {
+		ret[i] = tt.Status()
 	}
+	return ret
+}
+
+func (t *Tasks) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Tasks   map[TaskId]TaskStatus
+		History []TaskStatus
+	}{t.Running(), t.Finished()})
 }
 
 func (t *Tasks) Close() {
+	// Cancel under the lock so no task is added once waiting starts.
+	t.mu.Lock()
 	t.cancel()
+	t.mu.Unlock()
 	t.wg.Wait()
 }
 
+// Drain stops new script tasks from starting and gives running ones up to
+// d to finish before killing every task.
+func (t *Tasks) Drain(d time.Duration) {
+	t.mu.Lock()
+	t.draining = true
+	var donecs []<-chan struct{}
+	for _, tt := range t.Tasks {
+		if !tt.internal {
+			donecs = append(donecs, tt.donec)
+		}
+	}
+	t.mu.Unlock()
+	timeout := time.After(d)
+	for _, donec := range donecs {
+		select {
+		case <-donec:
+		case <-timeout:
+			log.Printf("[task] killing tasks after %v drain", d)
+			t.Close()
+			return
+		}
+	}
+	t.Close()
+}
+
 func (t *Tasks) Write(tid TaskId, msg irc.Message) error {
 	t.mu.RLock()
 	tt, ok := t.Tasks[tid]
@@ -104,6 +406,13 @@ func (t *Tasks) Write(tid TaskId, msg irc.Message) error {
 	return tt.Write(msg)
 }
 
+// LookupToken returns the running task holding a callback token.
+func (t *Tasks) LookupToken(tok string) *Task {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.tokens[tok]
+}
+
 func (t *Tasks) Kill(tid TaskId) error {
 	t.mu.RLock()
 	tt, ok := t.Tasks[tid]
@@ -111,37 +420,108 @@ func (t *Tasks) Kill(tid TaskId) error {
 	if !ok {
 		return io.EOF
 	}
-	tt.cancel()
+	tt.kill("killed")
 	<-tt.donec
 	return nil
 }
 
-// Run puts a command in the task list and schedules it to run.
+// Run puts an internal command in the task list and schedules it to run.
 func (t *Tasks) Run(name, cmdtxt string, f TaskFunc) {
-	cctx, cancel := context.WithCancel(t.ctx)
+	t.run(name, cmdtxt, Limits{}, true, f)
+}
+
+// RunLimited is Run with resource limits, failing if the task's group
+// already has MaxTasks running.
+func (t *Tasks) RunLimited(name, cmdtxt string, l Limits, f TaskFunc) error {
+	_, err := t.run(name, cmdtxt, l, false, f)
+	return err
+}
+
+// run starts a task, returning it so callers can wait on its donec.
+func (t *Tasks) run(name, cmdtxt string, l Limits, internal bool, f TaskFunc) (*Task, error) {
+	var cctx context.Context
+	var cancel context.CancelFunc
+	if l.WallMs > 0 {
+		cctx, cancel = context.WithTimeout(t.ctx, time.Duration(l.WallMs)*time.Millisecond)
+	} else {
+		cctx, cancel = context.WithCancel(t.ctx)
+	}
+	tok := make([]byte, 16)
+	if _, err := rand.Read(tok); err != nil {
+		cancel()
+		return nil, err
+	}
 	donec := make(chan struct{})
 	task := &Task{
-		Name: name, Start: Time(time.Now()), Command: cmdtxt,
-		mc: t.mc, ctx: cctx, cancel: cancel, donec: donec}
+		Name: name, Start: Time(time.Now()), Command: cmdtxt, limits: l,
+		internal: internal, token: hex.EncodeToString(tok), directive: t.directive,
+		mc: t.mc, fmtr: t.fmtr, ctx: cctx, cancel: cancel, donec: donec}
 	t.mu.Lock()
+	if err := t.ctx.Err(); err != nil {
+		t.mu.Unlock()
+		cancel()
+		return nil, err
+	} else if t.draining && !internal {
+		t.mu.Unlock()
+		cancel()
+		return nil, ErrDraining
+	}
+	if l.MaxTasks > 0 && t.running(l.Group) >= l.MaxTasks {
+		t.mu.Unlock()
+		cancel()
+		return nil, ErrTooManyTasks
+	}
 	t.tid++
 	tid := t.tid
 	t.Tasks[tid], task.tid = task, tid
-	t.mu.Unlock()
+	t.tokens[task.token] = task
 	t.wg.Add(1)
+	t.mu.Unlock()
 	go func() {
+		var err error
 		defer func() {
+			task.finish(err)
 			t.mu.Lock()
 			delete(t.Tasks, task.tid)
+			delete(t.tokens, task.token)
+			t.stats.record(task)
+			t.History = append(t.History, task)
+			if n := len(t.History); n > historyLen {
+				t.History = t.History[n-historyLen:]
+			}
 			t.mu.Unlock()
+			if t.audit != nil && task.audited() {
+				if err := t.audit.record(task); err != nil {
+					log.Printf("[task] could not audit %q (%v)", task.Command, err)
+				}
+			}
 			close(donec)
 			t.wg.Done()
 		}()
-		if t.limiter.Wait(task.ctx) != nil {
+		start := time.Now()
+		if err = t.limiter.Wait(task.ctx); err != nil {
 			return
 		}
-		if err := f(task); err != nil {
+		atomic.AddUint64(&t.stats.limitNs, uint64(time.Since(start)))
+		if err = f(task); err != nil {
 			log.Printf("[task] failed on command %q (%v)", task.Command, err)
 		}
 	}()
+	return task, nil
+}
+
+// idle reports whether no tasks are running.
+func (t *Tasks) idle() bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.Tasks) == 0
+}
+
+func (t *Tasks) running(group string) (n int) {
+	for _, tt := range t.Tasks {
+		if !tt.internal && tt.limits.Group == group {
+			n++
+		}
+	}
+	return n
 }
@@ -0,0 +1,115 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestTasksMaxTasks(t *testing.T) {
+	ts := NewTasks(context.Background(), rate.NewLimiter(rate.Inf, 1), nil, nil)
+	defer ts.Close()
+	blockc := make(chan struct{})
+	defer close(blockc)
+	block := func(*Task) error { <-blockc; return nil }
+	// The bot's own tasks leave room for scripts.
+	ts.Run("ping", "PING", block)
+	ts.Run("JOIN", "JOIN", block)
+	l := Limits{MaxTasks: 1}
+	if err := ts.RunLimited("echo", "echo", l, block); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.RunLimited("echo", "echo", l, block); err != ErrTooManyTasks {
+		t.Fatalf("got %v, want %v", err, ErrTooManyTasks)
+	}
+	if err := ts.RunLimited("dice", "dice", Limits{MaxTasks: 1, Group: "games"}, block); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTasksStatus(t *testing.T) {
+	ts := NewTasks(context.Background(), rate.NewLimiter(rate.Inf, 1), nil, nil)
+	defer ts.Close()
+	blockc := make(chan struct{})
+	running, err := ts.run("sleep", "sleep", Limits{}, false, func(t *Task) error {
+		<-blockc
+		t.kill("lines")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := ts.run("echo", "echo", Limits{}, false, func(*Task) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done.donec
+	// Snapshots may be taken while tasks finish.
+	go close(blockc)
+	for i := 0; i < 10; i++ {
+		if _, err := json.Marshal(ts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-running.donec
+
+	var got struct {
+		Tasks   map[TaskId]TaskStatus
+		History []TaskStatus
+	}
+	b, err := json.Marshal(ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Tasks) != 0 || len(got.History) != 2 {
+		t.Fatalf("got %d running, %d finished", len(got.Tasks), len(got.History))
+	}
+	if h := got.History[1]; h.Name != "sleep" || h.KillReason != "lines" {
+		t.Errorf("got %+v", h)
+	}
+}
+
+func TestTaskAllows(t *testing.T) {
+	task := &Task{tid: 7, targets: []string{"#games", "#quiz-*"}}
+	msg := func(cmd string, params ...string) irc.Message { return irc.Message{Command: cmd, Params: params} }
+	tests := []struct {
+		tid  TaskId
+		msg  irc.Message
+		want bool
+	}{
+		{0, msg(irc.PRIVMSG, "#games", "hi"), true},
+		{7, msg(irc.NOTICE, "#quiz-1", "hi"), true},
+		{0, msg(irc.PRIVMSG, "#Games", "hi"), true},
+		{0, msg(irc.PRIVMSG, "#QUIZ-2", "hi"), true},
+		{0, msg(irc.KICK, "#games", "bob"), true},
+		{0, msg(irc.INVITE, "bob", "#games"), true},
+		{0, msg(irc.PRIVMSG, "#admin", "hi"), false},
+		{0, msg(irc.PRIVMSG, "bob", "hi"), false},
+		{0, msg(irc.INVITE, "#games", "#admin"), false},
+		{0, msg(irc.PRIVMSG), false},
+		{0, msg(irc.QUIT, "bye"), false},
+		{0, msg(irc.NICK, "evil"), false},
+		// Other tasks' ids are off limits.
+		{8, msg(irc.PRIVMSG, "#games", "hi"), false},
+		{7, msg(irc.KILL), true},
+		{8, msg(irc.KILL), false},
+	}
+	for _, tt := range tests {
+		if got := task.Allows(tt.tid, tt.msg); got != tt.want {
+			t.Errorf("Allows(%d, %v) = %v, want %v", tt.tid, tt.msg, got, tt.want)
+		}
+	}
+	// Killing other tasks takes KillTasks, not just a wide target list.
+	if all := (&Task{tid: 1, targets: []string{"*"}}); all.Allows(8, msg(irc.KILL)) {
+		t.Errorf("wildcard task could kill")
+	}
+	if killer := (&Task{tid: 1, killAny: true}); !killer.Allows(8, msg(irc.KILL)) {
+		t.Errorf("KillTasks task could not kill")
+	}
+}
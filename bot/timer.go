@@ -0,0 +1,227 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// maxTimers bounds the pending timers of a bot.
+const maxTimers = 64
+
+var ErrTimerNotFound = errors.New("timer not found")
+
+// Timer sends a message or runs a script at a later time.
+type Timer struct {
+	Name string
+	At   Time
+	// DelayMs sets At relative to when the timer is added.
+	DelayMs int `json:",omitempty"`
+	Target  string
+	Message string `json:",omitempty"`
+	Command string `json:",omitempty"`
+}
+
+type pendingTimer struct {
+	Timer
+	t *time.Timer
+	// owner is the token of the task that added the timer, if any, and
+	// targets are where that task could write.
+	owner   string
+	targets []string
+}
+
+// ownedBy reports whether the task holding tok may see the timer; the
+// empty token is the bot's owner and sees every timer.
+func (pt *pendingTimer) ownedBy(tok string) bool { return tok == "" || pt.owner == tok }
+
+func taskToken(t *Task) string {
+	if t == nil {
+		return ""
+	}
+	return t.token
+}
+
+// Timers holds a bot's pending timers.
+type Timers struct {
+	d      *Dispatcher
+	ctx    context.Context
+	timers map[string]*pendingTimer
+	mu     sync.Mutex
+}
+
+func NewTimers(ctx context.Context, d *Dispatcher) *Timers {
+	return &Timers{d: d, ctx: ctx, timers: make(map[string]*pendingTimer)}
+}
+
+// Add schedules a timer, replacing any pending timer of the same name.
+func (ts *Timers) Add(tm Timer) error { return ts.AddFor(nil, tm) }
+
+// AddFor is Add on behalf of task t, which may only replace its own timers
+// and only run its own script. The timer's script keeps t's targets.
+func (ts *Timers) AddFor(t *Task, tm Timer) error {
+	if tm.Name == "" || tm.Target == "" {
+		return fmt.Errorf("timer needs Name and Target")
+	} else if (tm.Message == "") == (tm.Command == "") {
+		return fmt.Errorf("timer %q needs one of Message or Command", tm.Name)
+	}
+	var targets []string
+	if t != nil {
+		t.mu.Lock()
+		script := t.script
+		targets = append(targets, t.targets...)
+		t.mu.Unlock()
+		if cmd, _ := sandboxArgs(tm.Command); tm.Command != "" && cmd != script {
+			return fmt.Errorf("timer %q may only run %q", tm.Name, script)
+		}
+	}
+	if tm.DelayMs > 0 {
+		tm.At = Time(time.Now().Add(time.Duration(tm.DelayMs) * time.Millisecond))
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if err := ts.ctx.Err(); err != nil {
+		return err
+	}
+	tok := taskToken(t)
+	old := ts.timers[tm.Name]
+	if old == nil && len(ts.timers) >= maxTimers {
+		return fmt.Errorf("too many timers")
+	} else if old != nil && !old.ownedBy(tok) {
+		return fmt.Errorf("timer %q is taken", tm.Name)
+	} else if old != nil {
+		old.t.Stop()
+	}
+	pt := &pendingTimer{Timer: tm, owner: tok, targets: targets}
+	pt.t = time.AfterFunc(time.Until(tm.At.T()), func() { ts.fire(pt) })
+	ts.timers[tm.Name] = pt
+	return nil
+}
+
+func (ts *Timers) Cancel(name string) error { return ts.CancelFor(nil, name) }
+
+// CancelFor is Cancel on behalf of task t, which only finds its own timers.
+func (ts *Timers) CancelFor(t *Task, name string) error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	pt, ok := ts.timers[name]
+	if !ok || !pt.ownedBy(taskToken(t)) {
+		return ErrTimerNotFound
+	}
+	pt.t.Stop()
+	delete(ts.timers, name)
+	return nil
+}
+
+// List returns the pending timers, soonest first.
+func (ts *Timers) List() []Timer { return ts.ListFor(nil) }
+
+// ListFor is List limited to the timers added by task t.
+func (ts *Timers) ListFor(t *Task) (ret []Timer) {
+	tok := taskToken(t)
+	ts.mu.Lock()
+	for _, pt := range ts.timers {
+		if pt.ownedBy(tok) {
+			ret = append(ret, pt.Timer)
+		}
+	}
+	ts.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].At.T().Before(ret[j].At.T()) })
+	return ret
+}
+
+func (ts *Timers) MarshalJSON() ([]byte, error) { return json.Marshal(ts.List()) }
+
+func (ts *Timers) fire(pt *pendingTimer) {
+	ts.mu.Lock()
+	if ts.timers[pt.Name] != pt {
+		ts.mu.Unlock()
+		return
+	}
+	delete(ts.timers, pt.Name)
+	ts.mu.Unlock()
+	name := "timer " + pt.Name
+	if pt.Command != "" {
+		targets := pt.targets
+		if targets == nil {
+			targets = []string{pt.Target}
+		}
+		if err := ts.d.runScript(name, pt.Command, pt.Target, targets, nil, "SITBOT_TIMER="+pt.Name); err != nil {
+			log.Printf("[timer] could not run %q (%v)", pt.Name, err)
+		}
+		return
+	}
+	ts.d.Tasks.Run(name, pt.Message, func(t *Task) error {
+		for _, l := range t.fmtr.Split(pt.Message, t.fmtr.Budget(irc.PRIVMSG, pt.Target)) {
+			if err := t.Write(irc.Message{Command: irc.PRIVMSG, Params: []string{pt.Target, l}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ts *Timers) Close() {
+	ts.mu.Lock()
+	for _, pt := range ts.timers {
+		pt.t.Stop()
+	}
+	ts.timers = make(map[string]*pendingTimer)
+	ts.mu.Unlock()
+}
+
+// directive handles a control line from a task's output to tgt:
+//
+//	timer <name> <delay> msg <text>
+//	timer <name> <delay> run <command>
+//	untimer <name>
+//
+// The text after the action is kept as written, spacing included.
+func (ts *Timers) directive(t *Task, tgt, l string) error {
+	fs, rest := cutFields(l, 4)
+	if len(fs) == 2 && fs[0] == "untimer" {
+		return ts.CancelFor(t, fs[1])
+	}
+	if len(fs) < 4 || fs[0] != "timer" || strings.TrimSpace(rest) == "" {
+		return fmt.Errorf("bad directive %q", l)
+	}
+	delay, err := time.ParseDuration(fs[2])
+	if err != nil {
+		return err
+	}
+	tm := Timer{Name: fs[1], At: Time(time.Now().Add(delay)), Target: tgt}
+	switch fs[3] {
+	case "msg":
+		tm.Message = rest
+	case "run":
+		tm.Command = rest
+	default:
+		return fmt.Errorf("bad timer action %q", fs[3])
+	}
+	return ts.AddFor(t, tm)
+}
+
+// cutFields returns up to n space-separated fields from the front of s and
+// the text after the space ending the last of them.
+func cutFields(s string, n int) (fs []string, rest string) {
+	rest = s
+	for len(fs) < n {
+		f, r, ok := strings.Cut(strings.TrimLeft(rest, " "), " ")
+		if f == "" {
+			break
+		}
+		fs, rest = append(fs, f), r
+		if !ok {
+			break
+		}
+	}
+	return fs, rest
+}
@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestTimersOwner(t *testing.T) {
+	ts := NewTimers(context.Background(), nil)
+	defer ts.Close()
+	a, b := &Task{token: "a"}, &Task{token: "b"}
+	if err := ts.directive(a, "#t", "timer quiz 1h msg time is up"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Add(Timer{Name: "hi", DelayMs: 3600000, Target: "#t", Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(ts.List()); n != 2 {
+		t.Fatalf("got %d timers, want 2", n)
+	}
+	if l := ts.ListFor(a); len(l) != 1 || l[0].Name != "quiz" {
+		t.Errorf("task a listed %+v", l)
+	}
+	if l := ts.ListFor(b); len(l) != 0 {
+		t.Errorf("task b listed %+v", l)
+	}
+	if err := ts.CancelFor(b, "quiz"); err != ErrTimerNotFound {
+		t.Errorf("task b cancel got %v", err)
+	}
+	if err := ts.directive(b, "#t", "timer quiz 1s msg mine now"); err == nil {
+		t.Errorf("task b replaced task a's timer")
+	}
+	if err := ts.directive(a, "#t", "untimer quiz"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.CancelFor(a, "hi"); err != ErrTimerNotFound {
+		t.Errorf("task a cancel of the owner's timer got %v", err)
+	}
+	if err := ts.Cancel("hi"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTimerDirective(t *testing.T) {
+	ts := NewTimers(context.Background(), nil)
+	defer ts.Close()
+	a := &Task{token: "a", script: "quiz", targets: []string{"#t", "#quiz-*"}}
+	tests := []struct {
+		l, msg, cmd string
+	}{
+		{"timer art 1h msg  /\\_/\\   ( o.o )", " /\\_/\\   ( o.o )", ""},
+		{"timer  next  1h  run quiz  next", "", "quiz  next"},
+	}
+	for _, tt := range tests {
+		if err := ts.directive(a, "#t", tt.l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := make(map[string]Timer)
+	for _, tm := range ts.List() {
+		got[tm.Name] = tm
+	}
+	if tm := got["art"]; tm.Message != tests[0].msg {
+		t.Errorf("got message %q, want %q", tm.Message, tests[0].msg)
+	}
+	if tm := got["next"]; tm.Command != tests[1].cmd {
+		t.Errorf("got command %q, want %q", tm.Command, tests[1].cmd)
+	}
+	ts.mu.Lock()
+	targets := ts.timers["next"].targets
+	ts.mu.Unlock()
+	if !reflect.DeepEqual(targets, a.targets) {
+		t.Errorf("timer script may write to %q, want %q", targets, a.targets)
+	}
+	// A task's timers may only run the task's own script.
+	for _, l := range []string{"timer x 1h msg", "timer x 1h msg   ", "untimer", "timer x 1h say hi", "timer x 1h run kick.super bob"} {
+		if err := ts.directive(a, "#t", l); err == nil {
+			t.Errorf("expected error for %q", l)
+		}
+	}
+}
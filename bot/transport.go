@@ -0,0 +1,102 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+// Transport carries a bot's messages to and from a chat network. Messages
+// are IRC messages whatever the network, so the dispatcher, patterns,
+// tasks, and scripts work unchanged.
+type Transport interface {
+	// Decode returns the next message, or nil for a message it could not
+	// parse.
+	Decode() (*irc.Message, error)
+	Encode(*irc.Message) error
+	Close() error
+}
+
+// TransportDialer opens a Transport to a profile's ServerURL.
+type TransportDialer func(ctx context.Context, p *Profile, u *url.URL) (Transport, error)
+
+var transports = struct {
+	m  map[string]TransportDialer
+	mu sync.RWMutex
+}{m: map[string]TransportDialer{
+	"irc":   dialIRC,
+	"jsonl": dialJSONL,
+	"loop":  dialLoopback,
+}}
+
+// RegisterTransport makes bots dial ServerURLs with scheme using d.
+func RegisterTransport(scheme string, d TransportDialer) {
+	transports.mu.Lock()
+	defer transports.mu.Unlock()
+	transports.m[scheme] = d
+}
+
+func transportDialer(scheme string) TransportDialer {
+	transports.mu.RLock()
+	defer transports.mu.RUnlock()
+	return transports.m[scheme]
+}
+
+// DialTransport connects to the profile's server using the transport
+// registered for its URL scheme.
+func (p *Profile) DialTransport(ctx context.Context) (Transport, error) {
+	u, err := url.Parse(p.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+	d := transportDialer(u.Scheme)
+	if d == nil {
+		return nil, fmt.Errorf("no transport for scheme %q", u.Scheme)
+	}
+	return d(ctx, p, u)
+}
+
+// ircTransport speaks IRC over a network connection.
+type ircTransport struct {
+	*irc.Conn
+	conn net.Conn
+}
+
+func NewIRCTransport(conn net.Conn) Transport {
+	return &ircTransport{Conn: irc.NewConn(conn), conn: conn}
+}
+
+func (t *ircTransport) LocalAddr() net.Addr  { return t.conn.LocalAddr() }
+func (t *ircTransport) RemoteAddr() net.Addr { return t.conn.RemoteAddr() }
+
+func dialIRC(ctx context.Context, p *Profile, u *url.URL) (Transport, error) {
+	conn, err := p.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewIRCTransport(conn), nil
+}
+
+// transportAddr describes where a transport is connected, for logging.
+func transportAddr(tr Transport) string {
+	if a, ok := tr.(interface{ RemoteAddr() net.Addr }); ok {
+		return a.RemoteAddr().String()
+	}
+	return fmt.Sprintf("%T", tr)
+}
+
+// localIP returns the IP address of the transport's local end, if any.
+func localIP(tr Transport) net.IP {
+	a, ok := tr.(interface{ LocalAddr() net.Addr })
+	if !ok {
+		return nil
+	}
+	if ta, ok := a.LocalAddr().(*net.TCPAddr); ok {
+		return ta.IP
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+)
+
+func TestLoopbackBot(t *testing.T) {
+	l, err := ListenLoopback("transport-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	botc := make(chan *Bot, 1)
+	go func() {
+		p := Profile{ProfileLogin: ProfileLogin{ServerURL: "loop://transport-test", Nick: "tb"},
+			Chans: []string{"#t"}, RateMs: 1}
+		b, err := NewBot(ctx, p)
+		if err != nil {
+			t.Error(err)
+		}
+		botc <- b
+	}()
+	srv, err := l.Accept(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	expect := func(cmd string) *irc.Message {
+		msg, err := srv.Decode()
+		if err != nil {
+			t.Fatal(err)
+		} else if msg.Command != cmd {
+			t.Fatalf("got %q, want %s", msg, cmd)
+		}
+		return msg
+	}
+	expect(irc.NICK)
+	expect(irc.USER)
+	srv.Encode(&irc.Message{Command: irc.RPL_WELCOME, Params: []string{"tb", "Welcome tb!u@h"}})
+	if msg := expect(irc.JOIN); msg.Params[0] != "#t" {
+		t.Fatalf("joined %q", msg.Params[0])
+	}
+	srv.Encode(&irc.Message{Command: irc.PING, Params: []string{"x"}})
+	expect(irc.PONG)
+	if b := <-botc; b != nil {
+		b.Close()
+	}
+}
+
+func TestJSONTransport(t *testing.T) {
+	c1, c2 := net.Pipe()
+	a, b := NewJSONTransport(c1), NewJSONTransport(c2)
+	defer a.Close()
+	defer b.Close()
+	in := irc.ParseMessage(":alice!a@h PRIVMSG #t :hello there")
+	go a.Encode(in)
+	out, err := b.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != in.String() {
+		t.Fatalf("got %q, want %q", out, in)
+	}
+}
+
+func TestTxHook(t *testing.T) {
+	c1, c2 := net.Pipe()
+	srv := NewIRCTransport(c2)
+	defer srv.Close()
+	mc, err := NewMsgConn(context.Background(), c1, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Close()
+	go func() {
+		for {
+			if _, err := srv.Decode(); err != nil {
+				return
+			}
+		}
+	}()
+	// A stalled hook must not hold up sending or lose messages.
+	releasec := make(chan struct{})
+	var got []string
+	mc.SetTxHook(func(msg irc.Message, _ time.Time) {
+		<-releasec
+		got = append(got, msg.Params[0])
+	})
+	const n = 20
+	for i := 0; i < n; i++ {
+		mc.WriteMsg(irc.Message{Command: irc.PING, Params: []string{fmt.Sprint(i)}})
+	}
+	c, stop := mc.TapTx()
+	defer stop()
+	mc.WriteMsg(irc.Message{Command: irc.PING, Params: []string{"last"}})
+	for sent := false; !sent; {
+		select {
+		case msg := <-c:
+			sent = msg.Params[0] == "last"
+		case <-time.After(5 * time.Second):
+			t.Fatal("sending stalled behind the hook")
+		}
+	}
+	close(releasec)
+	mc.SetTxHook(nil)
+	if len(got) != n+1 || got[0] != "0" || got[n-1] != fmt.Sprint(n-1) || got[n] != "last" {
+		t.Errorf("hook got %q", got)
+	}
+}
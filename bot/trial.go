@@ -0,0 +1,193 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/time/rate"
+	"gopkg.in/sorcix/irc.v2"
+)
+
+const defaultTrialMs = 10000
+
+// TrialRequest is a message to check against a profile's patterns.
+type TrialRequest struct {
+	// Text is sent as a PRIVMSG from From to Chan.
+	Text string `json:",omitempty"`
+	From string `json:",omitempty"`
+	Chan string `json:",omitempty"`
+	// Raw is a raw IRC line, used instead of Text.
+	Raw string `json:",omitempty"`
+	// Run runs the triggered scripts and captures their output.
+	Run       bool `json:",omitempty"`
+	TimeoutMs int  `json:",omitempty"`
+}
+
+// TrialMatch is a pattern matching the trial message.
+type TrialMatch struct {
+	Match
+	// PatternsRaw is set if the pattern is from PatternsRaw.
+	PatternsRaw bool
+	// Fires is set on the match the bot would run; the rest are shadowed
+	// by an earlier pattern.
+	Fires bool
+	// Command is the sandbox command line with its output target and
+	// environment (less the os environment).
+	Command []string
+	Target  string
+	Env     []string
+}
+
+type TrialResult struct {
+	// Message is the raw line of the trial message.
+	Message string
+	Matches []TrialMatch
+	// Note explains why Patterns were not tried, such as for a CTCP
+	// query the bot answers itself.
+	Note string `json:",omitempty"`
+	// Output is what the scripts sent, if run.
+	Output []string `json:",omitempty"`
+	Err    string   `json:",omitempty"`
+}
+
+func (req *TrialRequest) message(p *Profile) (irc.Message, error) {
+	if req.Raw != "" {
+		msg := irc.ParseMessage(req.Raw)
+		if msg == nil {
+			return irc.Message{}, fmt.Errorf("bad raw line %q", req.Raw)
+		}
+		return *msg, nil
+	} else if req.Text == "" {
+		return irc.Message{}, fmt.Errorf("trial needs Text or Raw")
+	}
+	from, ch := req.From, req.Chan
+	if from == "" {
+		from = "tester"
+	}
+	if ch == "" {
+		ch = "#test"
+		if len(p.Chans) > 0 {
+			ch = p.Chans[0]
+		}
+	}
+	return irc.Message{
+		Prefix:  &irc.Prefix{Name: from, User: from, Host: "localhost"},
+		Command: irc.PRIVMSG,
+		Params:  []string{ch, req.Text},
+	}, nil
+}
+
+// Trial reports what a message would trigger on a bot with the profile.
+func (p *Profile) Trial(ctx context.Context, req TrialRequest) (*TrialResult, error) {
+	msg, err := req.message(p)
+	if err != nil {
+		return nil, err
+	}
+	pm, err := NewPatternMatcher(p.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	pmraw, err := NewPatternMatcher(p.PatternsRaw)
+	if err != nil {
+		return nil, err
+	}
+	d := NewDispatcher(p, nil)
+	res := &TrialResult{Message: msg.String()}
+	if msg.Command == irc.PRIVMSG && msg.Prefix != nil && len(msg.Params) > 1 {
+		if typ, txt, ok := patternText(msg.Params[1]); !ok {
+			res.Note = "answered by CTCP"
+		} else {
+			for i, m := range pm.Matches(typ, txt) {
+				cmdtxt, tgt, env := d.privMsgCmd(m.Template, msg)
+				res.Matches = append(res.Matches, trialMatch(m, false, i == 0, cmdtxt, tgt, env))
+			}
+		}
+	}
+	for i, m := range pmraw.Matches("", rawLine(msg)) {
+		res.Matches = append(res.Matches, trialMatch(m, true, i == 0, m.Template, p.Nick, d.Env()))
+	}
+	if req.Run && len(res.Matches) > 0 {
+		timeout := time.Duration(req.TimeoutMs) * time.Millisecond
+		if timeout <= 0 {
+			timeout = defaultTrialMs * time.Millisecond
+		}
+		if res.Output, err = p.trialRun(ctx, msg, timeout); err != nil {
+			res.Err = err.Error()
+		}
+	}
+	return res, nil
+}
+
+func trialMatch(m Match, raw, fires bool, cmdtxt, tgt string, env []string) TrialMatch {
+	script, args := sandboxArgs(cmdtxt)
+	return TrialMatch{
+		Match:       m,
+		PatternsRaw: raw,
+		Fires:       fires,
+		Command:     append([]string{ScriptDir + "/sandbox"}, args...),
+		Target:      tgt,
+		Env:         append(env, taskEnv(0, "<token>", script)...),
+	}
+}
+
+// trialRun dispatches msg on a bot connected to a pipe, returning the lines
+// its tasks send.
+func (p *Profile) trialRun(ctx context.Context, msg irc.Message, timeout time.Duration) ([]string, error) {
+	cctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	fmtr, err := NewFormatter(p.Charset)
+	if err != nil {
+		return nil, err
+	}
+	fmtr.SetPrefix(&irc.Prefix{Name: p.Nick})
+	// Sessions would hold stdin open until they time out.
+	tp := *p
+	tp.Patterns = append([]Pattern{}, p.Patterns...)
+	for i := range tp.Patterns {
+		tp.Patterns[i].SessionMs = 0
+	}
+	c1, c2 := LoopbackPair()
+	mc, err := NewTransportMsgConn(cctx, c1, 0)
+	if err != nil {
+		return nil, err
+	}
+	ts := NewTasks(cctx, rate.NewLimiter(rate.Inf, 1), mc, fmtr)
+	d := NewDispatcher(&tp, ts)
+	if err := d.Update(tp.Patterns, tp.PatternsRaw); err != nil {
+		mc.Close()
+		c2.Close()
+		return nil, err
+	}
+
+	// A PING after the tasks finish marks the end of their output.
+	done := irc.Message{Command: irc.PING, Params: []string{"trial"}}
+	var out []string
+	donec := make(chan struct{})
+	go func() {
+		defer close(donec)
+		for {
+			m, err := c2.Decode()
+			if err != nil || (m != nil && m.Command == done.Command && m.Trailing() == "trial") {
+				return
+			} else if m == nil {
+				continue
+			}
+			out = append(out, m.String())
+		}
+	}()
+	d.Process(msg)
+	ts.wg.Wait()
+	if mc.WriteMsg(done) == nil {
+		select {
+		case <-donec:
+		case <-cctx.Done():
+		}
+	}
+	err = cctx.Err()
+	ts.Close()
+	mc.Close()
+	c2.Close()
+	<-donec
+	return out, err
+}
@@ -0,0 +1,40 @@
+package bot
+
+import (
+	"context"
+	"testing"
+)
+
+func TestTrial(t *testing.T) {
+	p := &Profile{Id: "a", ProfileLogin: ProfileLogin{Nick: "bot"},
+		Patterns: []Pattern{
+			{Match: "^!(?P<cmd>\\w+)", Template: "$cmd %s"},
+			{Match: "^!", Template: "shadowed"}},
+		PatternsRaw: []Pattern{{Match: "PRIVMSG", Template: "raw"}}}
+	res, err := p.Trial(context.Background(), TrialRequest{Text: "!echo", From: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 3 {
+		t.Fatalf("got %+v", res.Matches)
+	}
+	m := res.Matches[0]
+	if !m.Fires || m.Template != "echo %s" || m.Target != "#test" || m.Command[1] != "echo" || m.Command[2] != "bob" {
+		t.Errorf("got %+v", m)
+	}
+	if res.Matches[1].Fires || !res.Matches[2].Fires || !res.Matches[2].PatternsRaw {
+		t.Errorf("got %+v", res.Matches)
+	}
+}
+
+func TestTrialCTCPAnswered(t *testing.T) {
+	p := &Profile{Id: "a", ProfileLogin: ProfileLogin{Nick: "bot"},
+		Patterns: []Pattern{{Match: "^", Template: "any"}}}
+	res, err := p.Trial(context.Background(), TrialRequest{Text: "\x01VERSION\x01", From: "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Matches) != 0 || res.Note != "answered by CTCP" {
+		t.Errorf("got %+v", res)
+	}
+}
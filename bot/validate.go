@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/chzchzchz/sitbot/bot/dcc"
+)
+
+// Problem is a validation failure at a field path like "mainbot.Patterns[2].Match".
+type Problem struct {
+	Field string
+	Msg   string
+}
+
+func (p Problem) String() string { return p.Field + ": " + p.Msg }
+
+// Problems is every validation failure found in a set of profiles.
+type Problems []Problem
+
+func (ps Problems) Error() string {
+	s := make([]string, len(ps))
+	for i, p := range ps {
+		s[i] = p.String()
+	}
+	return strings.Join(s, "; ")
+}
+
+// Err returns ps as an error, or nil if there are no problems.
+func (ps Problems) Err() error {
+	if len(ps) == 0 {
+		return nil
+	}
+	return ps
+}
+
+type validator struct {
+	pfx       string
+	scriptDir string
+	probs     Problems
+}
+
+func (v *validator) add(field, format string, args ...interface{}) {
+	v.probs = append(v.probs, Problem{v.pfx + field, fmt.Sprintf(format, args...)})
+}
+
+// ValidateProfiles checks profiles without applying them. Scripts named by
+// templates must exist in scriptDir unless it is empty.
+func ValidateProfiles(ps []*Profile, scriptDir string) Problems {
+	v := &validator{scriptDir: scriptDir}
+	ids := make(map[string]int)
+	for i, p := range ps {
+		v.pfx = p.Id + "."
+		if p.Id == "" {
+			v.pfx = fmt.Sprintf("[%d].", i)
+			v.add("Id", "missing")
+		} else if j, ok := ids[p.Id]; ok {
+			v.add("Id", "duplicate of profile %d", j)
+		} else {
+			ids[p.Id] = i
+		}
+		v.profile(p)
+	}
+	return v.probs
+}
+
+func (v *validator) profile(p *Profile) {
+	if p.Nick == "" {
+		v.add("Nick", "missing")
+	}
+	if u, err := url.Parse(p.ServerURL); err != nil {
+		v.add("ServerURL", "%v", err)
+	} else if transportDialer(u.Scheme) == nil {
+		v.add("ServerURL", "no transport for scheme %q", u.Scheme)
+	} else if u.Scheme == "loop" {
+		// Loopbacks are named, not addressed.
+		if u.Host == "" {
+			v.add("ServerURL", "missing loopback name")
+		}
+	} else if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		v.add("ServerURL", "%v", err)
+	}
+	if p.ProxyURL != "" {
+		if u, err := url.Parse(p.ProxyURL); err != nil {
+			v.add("ProxyURL", "%v", err)
+		} else if _, err := proxy.FromURL(u, proxy.Direct); err != nil {
+			v.add("ProxyURL", "%v", err)
+		}
+	}
+	if p.RateMs < 0 {
+		v.add("RateMs", "negative")
+	}
+	if _, err := NewFormatter(p.Charset); err != nil {
+		v.add("Charset", "%v", err)
+	}
+	v.patterns("Patterns", p.Patterns)
+	v.patterns("PatternsRaw", p.PatternsRaw)
+	names := make(map[string]struct{})
+	for i, s := range p.Schedules {
+		f := fmt.Sprintf("Schedules[%d]", i)
+		if _, ok := names[s.Name]; ok {
+			v.add(f+".Name", "duplicate %q", s.Name)
+		}
+		names[s.Name] = struct{}{}
+		if _, err := compileSchedules([]Schedule{s}); err != nil {
+			v.add(f, "%v", err)
+		}
+		v.script(f+".Template", s.Template)
+	}
+	if p.CTCP != nil && p.CTCP.RateMs < 0 {
+		v.add("CTCP.RateMs", "negative")
+	}
+	if p.DCC != nil {
+		v.dcc(p.DCC)
+	}
+	if s := p.Services; s != nil {
+		if s.Password == "" && (s.Ghost || s.WaitIdentify) {
+			v.add("Services.Password", "missing")
+		}
+		if s.IdentifyWaitMs < 0 || s.RejoinMs < 0 || s.Rejoins < 0 {
+			v.add("Services", "negative wait or rejoins")
+		}
+	}
+	for i, cg := range p.Guards {
+		if _, err := compileGuard(&cg); err != nil {
+			v.add(fmt.Sprintf("Guards[%d]", i), "%v", err)
+		}
+	}
+	for i, rl := range p.Relays {
+		v.relay(fmt.Sprintf("Relays[%d]", i), p.Id, &rl)
+	}
+}
+
+func (v *validator) relay(f, id string, rl *Relay) {
+	if rl.Channel == "" {
+		v.add(f+".Channel", "missing")
+	}
+	if rl.PeerChannel == "" {
+		v.add(f+".PeerChannel", "missing")
+	}
+	if rl.Peer == "" {
+		v.add(f+".Peer", "missing")
+	} else if rl.Peer == id && strings.EqualFold(rl.Channel, rl.PeerChannel) {
+		v.add(f+".Peer", "relays %s to itself", rl.Channel)
+	}
+	if _, err := rl.routes(id); err != nil {
+		v.add(f, "%v", err)
+	}
+}
+
+func (v *validator) dcc(c *dcc.Config) {
+	if c.PortMin < 0 || c.PortMax > 65535 || c.PortMax < c.PortMin {
+		v.add("DCC.PortMin", "bad port range %d-%d", c.PortMin, c.PortMax)
+	}
+	if c.ExternalIP != "" && net.ParseIP(c.ExternalIP) == nil {
+		v.add("DCC.ExternalIP", "bad address %q", c.ExternalIP)
+	}
+	if c.SendDir != "" {
+		if fi, err := os.Stat(c.SendDir); err != nil {
+			v.add("DCC.SendDir", "%v", err)
+		} else if !fi.IsDir() {
+			v.add("DCC.SendDir", "not a directory")
+		}
+	}
+}
+
+func (v *validator) patterns(field string, pats []Pattern) {
+	for i, pat := range pats {
+		f := fmt.Sprintf("%s[%d]", field, i)
+		re, err := regexp.Compile(pat.Match)
+		if err != nil {
+			v.add(f+".Match", "%v", err)
+		} else {
+			for _, ref := range templateRefs(pat.Template) {
+				if !hasGroup(re, ref) {
+					v.add(f+".Template", "no group %q in Match", ref)
+				}
+			}
+		}
+		v.script(f+".Template", pat.Template)
+		switch pat.Type {
+		case "", PatternAction, PatternCTCP:
+		default:
+			v.add(f+".Type", "unknown type %q", pat.Type)
+		}
+		for j, tgt := range pat.Targets {
+			if tgt == "" {
+				v.add(fmt.Sprintf("%s.Targets[%d]", f, j), "empty target")
+			}
+		}
+	}
+}
+
+// script checks the script named by a template exists; templates choosing
+// the script from the match are left to run time.
+func (v *validator) script(field, tmpl string) {
+	fs := strings.Fields(tmpl)
+	if v.scriptDir == "" || len(fs) == 0 || strings.Contains(fs[0], "$") {
+		return
+	}
+	name := strings.Replace(fs[0], "/", "_", -1)
+	if _, err := os.Stat(filepath.Join(v.scriptDir, name)); err != nil {
+		v.add(field, "no script %q in %s", name, v.scriptDir)
+	}
+}
+
+// templateRefs returns the group names and numbers referenced by a
+// regexp.Expand template.
+func templateRefs(tmpl string) (refs []string) {
+	for {
+		i := strings.IndexByte(tmpl, '$')
+		if i < 0 || i == len(tmpl)-1 {
+			return refs
+		}
+		tmpl = tmpl[i+1:]
+		if tmpl[0] == '$' {
+			tmpl = tmpl[1:]
+			continue
+		}
+		var name string
+		if tmpl[0] == '{' {
+			end := strings.IndexByte(tmpl, '}')
+			if end < 0 {
+				return refs
+			}
+			name, tmpl = tmpl[1:end], tmpl[end+1:]
+		} else {
+			end := strings.IndexFunc(tmpl, func(r rune) bool {
+				return !(r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+			})
+			if end < 0 {
+				end = len(tmpl)
+			}
+			name, tmpl = tmpl[:end], tmpl[end:]
+		}
+		if name != "" {
+			refs = append(refs, name)
+		}
+	}
+}
+
+func hasGroup(re *regexp.Regexp, ref string) bool {
+	if n, err := strconv.Atoi(ref); err == nil {
+		return n >= 0 && n <= re.NumSubexp()
+	}
+	return re.SubexpIndex(ref) >= 0
+}
@@ -0,0 +1,34 @@
+package bot
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplateRefs(t *testing.T) {
+	got := templateRefs("a $cmd ${1}x $$lit $2b $")
+	want := []string{"cmd", "1", "2b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestValidateProfiles(t *testing.T) {
+	ok := &Profile{Id: "a", ProfileLogin: ProfileLogin{Nick: "a", ServerURL: "irc://localhost:6667"},
+		Patterns: []Pattern{{Match: "^!(?P<cmd>\\w+)", Template: "$cmd"}}}
+	if probs := ValidateProfiles([]*Profile{ok}, ""); len(probs) != 0 {
+		t.Fatal(probs)
+	}
+	bad := &Profile{Id: "a", ProfileLogin: ProfileLogin{ServerURL: "irc://localhost"},
+		Patterns: []Pattern{{Match: "(", Template: "x"}, {Match: "(a)", Template: "$2"}}}
+	fields := []string{"a.Id", "a.Nick", "a.ServerURL", "a.Patterns[0].Match", "a.Patterns[1].Template"}
+	probs := ValidateProfiles([]*Profile{ok, bad}, "")
+	if len(probs) != len(fields) {
+		t.Fatalf("got %v", probs)
+	}
+	for i, p := range probs {
+		if p.Field != fields[i] {
+			t.Errorf("problem %d: got %q, want field %q", i, p, fields[i])
+		}
+	}
+}
@@ -53,6 +53,8 @@ func NewBouncer(b *bot.Bot, serv string) (*Bouncer, error) {
 			bounce.wg.Add(1)
 			go func() {
 				defer bounce.wg.Done()
+				b.AddClients(1)
+				defer b.AddClients(-1)
 				err := bounce.handleConn(mc)
 				log.Printf("bouncer closing %v (%v)", conn.RemoteAddr(), err)
 			}()
@@ -118,8 +120,7 @@ func (bounce *Bouncer) handleConn(mc *bot.MsgConn) error {
 		}
 	}
 	nnpfx2 := &irc.Prefix{Name: nnick}
-	bounce.b.RLock()
-	// TODO: get channel data from state watcher, not bot
+	bounce.b.State.RLock()
 	for c := range bounce.b.State.Channels {
 		// Have chat server return names list for channel as if joined.
 		wg.Add(1)
@@ -131,7 +132,7 @@ func (bounce *Bouncer) handleConn(mc *bot.MsgConn) error {
 			bounce.b.TeeMsg().WriteMsg(msg)
 		}(c)
 	}
-	bounce.b.RUnlock()
+	bounce.b.State.RUnlock()
 
 	brc, bdc := bounce.b.TeeMsg().NewReadChan()
 	defer close(bdc)
@@ -171,6 +172,9 @@ func (bounce *Bouncer) handleConn(mc *bot.MsgConn) error {
 	}
 }
 
+// Addr is the address the bouncer listens on.
+func (b *Bouncer) Addr() net.Addr { return b.ln.Addr() }
+
 func (b *Bouncer) Close() {
 	b.ln.Close()
 	b.cancel()
@@ -0,0 +1,74 @@
+package bouncer
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/sorcix/irc.v2"
+
+	"github.com/chzchzchz/sitbot/bot"
+	"github.com/chzchzchz/sitbot/bot/irctest"
+)
+
+func TestBouncer(t *testing.T) {
+	s := irctest.NewServer(t)
+	botc := make(chan *bot.Bot, 1)
+	go func() {
+		p := bot.Profile{ProfileLogin: bot.ProfileLogin{ServerURL: s.URL(), Nick: "bb"},
+			Chans: []string{"#b"}, RateMs: 1}
+		b, err := bot.NewBot(context.Background(), p)
+		if err != nil {
+			t.Error(err)
+		}
+		botc <- b
+	}()
+	srv := s.Client(t)
+	srv.Welcomed(t)
+	b := <-botc
+	if b == nil {
+		t.FailNow()
+	}
+	defer b.Close()
+	srv.Expect(t, irc.JOIN, "#b")
+
+	bounce, err := NewBouncer(b, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bounce.Close()
+	conn, err := net.Dial("tcp", bounce.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cc := irc.NewConn(conn)
+	cc.Encode(&irc.Message{Command: irc.NICK, Params: []string{"me"}})
+	cc.Encode(&irc.Message{Command: irc.USER, Params: []string{"me", "0", "*", "me"}})
+	conn.SetDeadline(time.Now().Add(irctest.Timeout))
+	// expect reads from the bouncer until a cmd message with text.
+	expect := func(cmd, text string) {
+		t.Helper()
+		for {
+			msg, err := cc.Decode()
+			if err != nil {
+				t.Fatalf("waiting for %s %q: %v", cmd, text, err)
+			} else if msg != nil && msg.Command == cmd && (text == "" || msg.Trailing() == text) {
+				return
+			}
+		}
+	}
+	expect(irc.RPL_WELCOME, "")
+
+	// The client talks through the bot.
+	cc.Encode(&irc.Message{Command: irc.PRIVMSG, Params: []string{"#b", "from the client"}})
+	srv.Expect(t, irc.PRIVMSG, "#b", "from the client")
+
+	// The client hears the bot's channels.
+	srv.Privmsg("alice", "#b", "to the client")
+	expect(irc.PRIVMSG, "to the client")
+	if n := b.Clients(); n != 1 {
+		t.Errorf("got %d clients", n)
+	}
+}
@@ -5,19 +5,49 @@ import (
 	"io/ioutil"
 	"net/http"
 	"path"
+	"sync"
 
 	"github.com/chzchzchz/sitbot/bot"
 )
 
-type httpHandler struct {
-	g *bot.Gang
+type Handler struct {
+	g        *bot.Gang
+	bouncers []*Bouncer
+	closed   bool
+	mu       sync.Mutex
 }
 
-func NewHandler(g *bot.Gang) http.Handler {
-	return &httpHandler{g: g}
+func NewHandler(g *bot.Gang) *Handler {
+	return &Handler{g: g}
 }
 
-func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+// Close stops every bouncer started by the handler.
+func (h *Handler) Close() {
+	h.mu.Lock()
+	bs := h.bouncers
+	h.bouncers, h.closed = nil, true
+	h.mu.Unlock()
+	for _, b := range bs {
+		b.Close()
+	}
+}
+
+// Start starts a bouncer for b listening on addr.
+func (h *Handler) Start(b *bot.Bot, addr string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return io.EOF
+	}
+	bounce, err := NewBouncer(b, addr)
+	if err != nil {
+		return err
+	}
+	h.bouncers = append(h.bouncers, bounce)
+	return nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		var err error
@@ -36,8 +66,7 @@ func (h *httpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			if bot == nil {
 				return io.EOF
 			}
-			_, err = NewBouncer(bot, string(b))
-			return err
+			return h.Start(bot, string(b))
 		}()
 	default:
 		http.Error(w, "Not allowed", http.StatusMethodNotAllowed)
@@ -1,31 +1,40 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/chzchzchz/sitbot/bot"
 	bothttp "github.com/chzchzchz/sitbot/bot/http"
 	"github.com/chzchzchz/sitbot/bouncer"
+	"github.com/chzchzchz/sitbot/kv"
 )
 
+// authHttpHandler admits local script callbacks holding a task token and
+// requests with the basic authentication credentials, if any are set.
+// Without credentials, only script callbacks are served.
 type authHttpHandler struct {
-	h    http.Handler
-	user string
-	pass string
+	h       http.Handler
+	scripts http.Handler
+	user    string
+	pass    string
 }
 
 func (h *authHttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err == nil && net.ParseIP(ip).IsLoopback() {
-		// Pass-through for any local accesses so script callbacks work.
-		h.h.ServeHTTP(w, r)
+	if err == nil && net.ParseIP(ip).IsLoopback() && bothttp.Token(r) != "" {
+		// Script callbacks authenticate with their task's token.
+		h.scripts.ServeHTTP(w, r)
 		return
 	}
-	if u, p, ok := r.BasicAuth(); !ok || u != h.user || p != h.pass {
+	if u, p, ok := r.BasicAuth(); !ok || h.user == "" || u != h.user || p != h.pass {
 		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
 		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		log.Printf("http: [%s] bad auth %q %q", r.RemoteAddr, u, p)
@@ -53,7 +62,21 @@ func main() {
 	userFlag := flag.String("u", "", "username for basic http authentication")
 	passFlag := flag.String("p", "", "password for basic http authentication")
 	corsFlag := flag.Bool("cors", false, "enable CORS")
+	kvFlag := flag.String("kv", "kv", "directory for script key-value stores")
+	profilesFlag := flag.String("profiles", "", "directory of profile files to load and watch")
+	drainFlag := flag.Duration("drain", 10*time.Second, "time for running tasks to finish on shutdown")
+	quitFlag := flag.String("quit", "shutting down", "QUIT message on shutdown")
+	auditFlag := flag.String("audit", "audit", "directory for task audit logs, empty to disable")
+	recordFlag := flag.String("record", "", "directory to record the bots' IRC traffic for replay")
 	flag.Parse()
+	switch flag.Arg(0) {
+	case "validate":
+		os.Exit(validate(flag.Args()[1:]))
+	case "test":
+		os.Exit(trial(flag.Args()[1:]))
+	case "replay":
+		os.Exit(replay(flag.Args()[1:]))
+	}
 
 	laddr := *laddrFlag
 	if os.Getenv("SITBOT_URL") == "" {
@@ -62,22 +85,81 @@ func main() {
 		os.Setenv("SITBOT_URL", "http://"+laddr)
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	mux := http.NewServeMux()
 
 	g := bot.NewGang()
-	mux.Handle("/", bothttp.NewGangHandler(g))
-	mux.Handle("/bouncer/", http.StripPrefix("/bouncer", bouncer.NewHandler(g)))
-	var h http.Handler
-	h = mux
+	g.AuditDir = *auditFlag
+	g.RecordDir = *recordFlag
+	kvd := kv.NewDir(*kvFlag)
+	bh := bouncer.NewHandler(g)
+	g.Bouncer = bh.Start
+	mux.Handle("/", bothttp.NewGangHandler(g, kvd))
+	mux.Handle("/bouncer/", http.StripPrefix("/bouncer", bh))
+	var pd *profileDir
+	if *profilesFlag != "" {
+		pd = newProfileDir(*profilesFlag, g)
+		if err := pd.LoadAll(); err != nil {
+			log.Fatal(err)
+		}
+		g.Reload = pd.LoadAll
+		go func() {
+			if err := pd.Watch(ctx); err != nil {
+				log.Printf("profiles: stopped watching %s (%v)", *profilesFlag, err)
+			}
+		}()
+	}
 	if len(*userFlag) > 0 {
 		log.Println("using basic authentication on user " + *userFlag)
-		h = &authHttpHandler{h: h, user: *userFlag, pass: *passFlag}
+	} else {
+		log.Println("no basic authentication; only script callbacks are served")
+	}
+	var h http.Handler = &authHttpHandler{
+		h:       mux,
+		scripts: bothttp.NewScriptHandler(g, kvd),
+		user:    *userFlag,
+		pass:    *passFlag,
 	}
 	if *corsFlag {
 		log.Println("enabling CORS")
 		h = &corsHandler{h: h}
 	}
 
-	log.Println("serving bot on", laddr)
-	http.ListenAndServe(laddr, h)
+	srv := &http.Server{Addr: laddr, Handler: h}
+	go func() {
+		log.Println("serving bot on", laddr)
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigc {
+		if sig != syscall.SIGHUP {
+			log.Printf("got %v, shutting down", sig)
+			break
+		}
+		if pd == nil {
+			log.Println("got SIGHUP, no profile directory to reload")
+		} else if err := pd.LoadAll(); err != nil {
+			log.Printf("profiles: reload failed (%v)", err)
+		} else {
+			log.Println("got SIGHUP, reloaded profiles")
+		}
+	}
+	signal.Stop(sigc)
+
+	// Stop taking new work, then let the bots finish up and quit. HTTP
+	// stays up during the drain so running scripts can still call back.
+	cancel()
+	bh.Close()
+	g.Shutdown(*drainFlag, *quitFlag)
+	sctx, scancel := context.WithTimeout(context.Background(), *drainFlag)
+	defer scancel()
+	if err := srv.Shutdown(sctx); err != nil {
+		log.Printf("http: shutdown (%v)", err)
+	}
+	log.Println("shutdown complete")
 }
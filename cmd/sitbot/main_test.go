@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chzchzchz/sitbot/bot"
+	bothttp "github.com/chzchzchz/sitbot/bot/http"
+)
+
+func TestAuthHttpHandler(t *testing.T) {
+	g := bot.NewGang()
+	mux := http.NewServeMux()
+	mux.Handle("/", bothttp.NewGangHandler(g, nil))
+	post := func(h http.Handler, auth func(*http.Request)) int {
+		srv := httptest.NewServer(h)
+		defer srv.Close()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/bot/b", strings.NewReader(`{"Command" : "QUIT"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth(req)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	none := func(*http.Request) {}
+	bogus := func(r *http.Request) { r.Header.Set("Authorization", "Bearer bogus") }
+	basic := func(r *http.Request) { r.SetBasicAuth("admin", "pw") }
+
+	// Without -u, local requests need a token.
+	noauth := &authHttpHandler{h: mux, scripts: bothttp.NewScriptHandler(g, nil)}
+	for _, f := range []func(*http.Request){none, bogus, basic} {
+		if code := post(noauth, f); code != http.StatusUnauthorized {
+			t.Errorf("got %d without -u, want %d", code, http.StatusUnauthorized)
+		}
+	}
+
+	auth := &authHttpHandler{h: mux, scripts: bothttp.NewScriptHandler(g, nil), user: "admin", pass: "pw"}
+	if code := post(auth, none); code != http.StatusUnauthorized {
+		t.Errorf("got %d without credentials, want %d", code, http.StatusUnauthorized)
+	}
+	if code := post(auth, bogus); code != http.StatusUnauthorized {
+		t.Errorf("got %d with a bogus token, want %d", code, http.StatusUnauthorized)
+	}
+	// The credentials reach the API, which has no such bot.
+	if code := post(auth, basic); code == http.StatusUnauthorized {
+		t.Errorf("credentials were refused")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+// profileDir keeps the gang in sync with the profile files in a directory.
+type profileDir struct {
+	dir string
+	g   *bot.Gang
+	// ids maps each file to the bot ids it defines.
+	ids map[string][]string
+	mu  sync.Mutex
+}
+
+func newProfileDir(dir string, g *bot.Gang) *profileDir {
+	return &profileDir{dir: dir, g: g, ids: make(map[string][]string)}
+}
+
+func isProfileFile(name string) bool {
+	switch filepath.Ext(name) {
+	case ".json", ".yaml", ".yml":
+		return !strings.HasPrefix(name, ".")
+	}
+	return false
+}
+
+// readProfiles decodes the profiles in a JSON or YAML file.
+func readProfiles(fn string) ([]*bot.Profile, error) {
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(fn); ext == ".yaml" || ext == ".yml" {
+		// Go through JSON so YAML keys match the JSON field names.
+		var v interface{}
+		if err := yaml.Unmarshal(b, &v); err != nil {
+			return nil, err
+		}
+		if b, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	return bot.DecodeProfiles(bytes.NewReader(b))
+}
+
+// owner returns the file other than fn defining a bot id.
+func (pd *profileDir) owner(fn, id string) string {
+	for f, ids := range pd.ids {
+		for _, i := range ids {
+			if i == id && f != fn {
+				return f
+			}
+		}
+	}
+	return ""
+}
+
+// LoadAll applies every profile file in the directory, including removals.
+func (pd *profileDir) LoadAll() error {
+	ents, err := os.ReadDir(pd.dir)
+	if err != nil {
+		return err
+	}
+	names := make(map[string]struct{})
+	for _, ent := range ents {
+		if !ent.IsDir() {
+			names[ent.Name()] = struct{}{}
+		}
+	}
+	// Include removed files so their bots are deleted.
+	pd.mu.Lock()
+	for fn := range pd.ids {
+		names[filepath.Base(fn)] = struct{}{}
+	}
+	pd.mu.Unlock()
+	for name := range names {
+		pd.Reload(name)
+	}
+	return nil
+}
+
+// Reload applies a changed, created, or removed profile file. Bad files
+// leave the running bots alone.
+func (pd *profileDir) Reload(name string) {
+	if !isProfileFile(name) {
+		return
+	}
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	fn := filepath.Join(pd.dir, name)
+	var ps []*bot.Profile
+	if _, err := os.Stat(fn); err == nil {
+		if ps, err = readProfiles(fn); err == nil {
+			err = bot.ValidateProfiles(ps, bot.ScriptDir).Err()
+		}
+		for _, p := range ps {
+			if f := pd.owner(fn, p.Id); err == nil && f != "" {
+				err = fmt.Errorf("%s: already defined in %s", p.Id, f)
+			}
+		}
+		if err != nil {
+			log.Printf("profiles: %s: %v", fn, err)
+			pd.g.SetError(fn, err)
+			return
+		}
+	}
+	ids := make(map[string]struct{})
+	for _, p := range ps {
+		ids[p.Id] = struct{}{}
+	}
+	for _, id := range pd.ids[fn] {
+		if _, ok := ids[id]; !ok {
+			log.Printf("profiles: %s: removing %s", fn, id)
+			pd.g.Delete(id)
+		}
+	}
+	var errs []string
+	pd.ids[fn] = nil
+	for _, p := range ps {
+		log.Printf("profiles: %s: applying %s", fn, p.Id)
+		if err := pd.g.Post(*p); err != nil {
+			log.Printf("profiles: %s: %s: %v", fn, p.Id, err)
+			errs = append(errs, p.Id+": "+err.Error())
+		}
+		pd.ids[fn] = append(pd.ids[fn], p.Id)
+	}
+	if len(pd.ids[fn]) == 0 {
+		delete(pd.ids, fn)
+	}
+	if len(errs) > 0 {
+		pd.g.SetError(fn, fmt.Errorf("%s", strings.Join(errs, "; ")))
+	} else {
+		pd.g.SetError(fn, nil)
+	}
+}
+
+// Watch reloads profile files as they change until ctx is done.
+func (pd *profileDir) Watch(ctx context.Context) error {
+	return watchDir(ctx, pd.dir, pd.Reload)
+}
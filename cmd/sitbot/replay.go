@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+// replay feeds a recording to a bot for a profile, printing the traffic.
+// It returns the process exit code.
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	idFlag := fs.String("id", "", "profile id if the file has several")
+	speedFlag := fs.Float64("speed", 0, "pace relative to the recording, 0 for no delays")
+	drainFlag := fs.Duration("drain", 10*time.Second, "time for tasks to finish after the recording ends")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sitbot replay [flags] profile-file recording")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+	ps, err := readProfiles(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var p *bot.Profile
+	for _, pp := range ps {
+		if *idFlag == "" || pp.Id == *idFlag {
+			p = pp
+			break
+		}
+	}
+	if p == nil {
+		fmt.Fprintf(os.Stderr, "no profile %q in %s\n", *idFlag, fs.Arg(0))
+		return 2
+	}
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	recs, err := bot.ReadRecords(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(1), err)
+		return 2
+	}
+	start := time.Now()
+	o := bot.ReplayOptions{Speed: *speedFlag, Drain: *drainFlag, Watch: func(r bot.Record) {
+		dir := "<-"
+		if r.Out {
+			dir = "->"
+		}
+		fmt.Printf("%8.3f %s %s\n", r.Time.T().Sub(start).Seconds(), dir, r.Msg)
+	}}
+	if err := bot.Replay(context.Background(), *p, recs, o); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
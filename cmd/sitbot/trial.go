@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+// trial shows what a message would trigger for a profile file. It returns
+// the process exit code.
+func trial(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	idFlag := fs.String("id", "", "profile id if the file has several")
+	rawFlag := fs.Bool("raw", false, "message is a raw IRC line")
+	fromFlag := fs.String("from", "", "sender of the message")
+	chanFlag := fs.String("chan", "", "channel of the message")
+	runFlag := fs.Bool("run", false, "run the matched scripts")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: sitbot test [flags] profile-file message...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+	ps, err := readProfiles(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var p *bot.Profile
+	for _, pp := range ps {
+		if *idFlag == "" || pp.Id == *idFlag {
+			p = pp
+			break
+		}
+	}
+	if p == nil {
+		fmt.Fprintf(os.Stderr, "no profile %q in %s\n", *idFlag, fs.Arg(0))
+		return 2
+	}
+	req := bot.TrialRequest{From: *fromFlag, Chan: *chanFlag, Run: *runFlag}
+	if l := strings.Join(fs.Args()[1:], " "); *rawFlag {
+		req.Raw = l
+	} else {
+		req.Text = l
+	}
+	res, err := p.Trial(context.Background(), req)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	fmt.Println("message:", res.Message)
+	for _, m := range res.Matches {
+		field := "Patterns"
+		if m.PatternsRaw {
+			field = "PatternsRaw"
+		}
+		fires := ""
+		if !m.Fires {
+			fires = " (shadowed)"
+		}
+		fmt.Printf("%s[%d]%s: %q -> %q\n", field, m.Index, fires, m.Pattern.Template, m.Template)
+		fmt.Printf("\tcommand: %s\n\ttarget: %s\n", strings.Join(m.Command, " "), m.Target)
+		for _, e := range m.Env {
+			fmt.Printf("\tenv: %s\n", e)
+		}
+	}
+	if res.Note != "" {
+		fmt.Println("note:", res.Note)
+	}
+	if len(res.Matches) == 0 {
+		fmt.Println("no patterns matched")
+		return 1
+	}
+	for _, l := range res.Output {
+		fmt.Println("output:", l)
+	}
+	if res.Err != "" {
+		fmt.Println("error:", res.Err)
+		return 1
+	}
+	return 0
+}
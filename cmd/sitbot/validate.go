@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/chzchzchz/sitbot/bot"
+)
+
+// validate checks profile files and directories of profile files, printing
+// every problem found. It returns the process exit code.
+func validate(args []string) int {
+	var fns []string
+	for _, arg := range args {
+		fi, err := os.Stat(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if !fi.IsDir() {
+			fns = append(fns, arg)
+			continue
+		}
+		ents, err := os.ReadDir(arg)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		for _, ent := range ents {
+			if !ent.IsDir() && isProfileFile(ent.Name()) {
+				fns = append(fns, filepath.Join(arg, ent.Name()))
+			}
+		}
+	}
+	ret, n := 0, 0
+	ids := make(map[string]string)
+	for _, fn := range fns {
+		ps, err := readProfiles(fn)
+		if err != nil {
+			fmt.Printf("%s: %v\n", fn, err)
+			ret = 1
+			continue
+		}
+		for _, prob := range bot.ValidateProfiles(ps, bot.ScriptDir) {
+			fmt.Printf("%s: %v\n", fn, prob)
+			ret = 1
+		}
+		for _, p := range ps {
+			if f, ok := ids[p.Id]; ok && f != fn {
+				fmt.Printf("%s: %s.Id: also defined in %s\n", fn, p.Id, f)
+				ret = 1
+			}
+			ids[p.Id] = fn
+		}
+		n += len(ps)
+	}
+	if ret == 0 {
+		fmt.Printf("%d profiles in %d files ok\n", n, len(fns))
+	}
+	return ret
+}
@@ -0,0 +1,46 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const watchPoll = 2 * time.Second
+
+// watchDir calls f with the name of each file modified or removed in dir
+// until ctx is done, polling since inotify is Linux-only.
+func watchDir(ctx context.Context, dir string, f func(name string)) error {
+	scan := func() map[string]time.Time {
+		m := make(map[string]time.Time)
+		ents, _ := os.ReadDir(dir)
+		for _, ent := range ents {
+			if fi, err := ent.Info(); err == nil {
+				m[ent.Name()] = fi.ModTime()
+			}
+		}
+		return m
+	}
+	last := scan()
+	for {
+		select {
+		case <-time.After(watchPoll):
+		case <-ctx.Done():
+			return nil
+		}
+		cur := scan()
+		for name, mt := range cur {
+			if omt, ok := last[name]; !ok || !omt.Equal(mt) {
+				f(name)
+			}
+		}
+		for name := range last {
+			if _, ok := cur[name]; !ok {
+				f(name)
+			}
+		}
+		last = cur
+	}
+}
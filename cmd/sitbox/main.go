@@ -1,8 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
+	"fmt"
 	"os"
+	"os/exec"
 	"strings"
 	"time"
 
@@ -13,21 +17,90 @@ const BackoffBase = time.Second
 const BackoffMul = 2
 const LineTimeout = 5 * time.Second
 
+// errNoNamespaces means the sandbox's namespaces can't be created or used,
+// the only failure that may run a script unisolated.
+var errNoNamespaces = errors.New("namespaces unavailable")
+
+// boxCmd is a script's command with what it holds until the script exits.
+type boxCmd struct {
+	*exec.Cmd
+	// setup reports whether the started command set up its sandbox.
+	setup   func() error
+	cleanup func()
+}
+
+// startCmd starts a script under policy p, running it unisolated when
+// namespaces are unavailable. Any other sandbox failure is an error.
+func startCmd(ctx context.Context, p *Policy, name string, args []string) (*bot.Cmd, func(), error) {
+	for {
+		bc, err := p.command(ctx, name, args)
+		if err != nil {
+			return nil, nil, err
+		}
+		cmd, err := bot.StartCmd(ctx, bc.Cmd)
+		if err == nil {
+			if err = bc.setup(); err == nil {
+				return cmd, bc.cleanup, nil
+			}
+			cmd.Close()
+		} else if p.Isolate && nsUnavailable(err) {
+			err = fmt.Errorf("%w (%v)", errNoNamespaces, err)
+		}
+		bc.cleanup()
+		if !errors.Is(err, errNoNamespaces) {
+			return nil, nil, err
+		}
+		np, ok := p.fallback()
+		if !ok {
+			return nil, nil, err
+		}
+		fmt.Fprintf(os.Stderr, "sitbox: WARNING: %v; running %s WITHOUT ISOLATION\n", err, name)
+		p = np
+	}
+}
+
 func main() {
-	if strings.Contains(os.Args[1], "/") {
+	if isInit() {
+		runInit()
+		return
+	}
+	if len(os.Args) < 2 || strings.Contains(os.Args[1], "/") {
+		os.Exit(1)
+	}
+	p, err := loadPolicy()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "sitbox:", err)
 		os.Exit(1)
 	}
+	timeout := p.lineTimeout(os.Args[1])
 	ctx, cancel := context.WithCancel(context.TODO())
-	cmdname := "scripts/" + os.Args[1]
-	cmd, err := bot.NewCmd(ctx, cmdname, os.Args[2:], nil)
+	cmd, cleanup, err := startCmd(ctx, p, os.Args[1], os.Args[2:])
 	if err != nil {
+		fmt.Fprintln(os.Stderr, "sitbox:", err)
 		cancel()
 		os.Exit(1)
 	}
+	code := 0
 	defer func() {
 		cancel()
-		if err := cmd.Close(); err != nil {
-			os.Exit(1)
+		if err := cmd.Close(); err != nil && code == 0 {
+			code = 1
+		}
+		cleanup()
+		os.Exit(code)
+	}()
+	inc := make(chan string)
+	go func() {
+		defer close(inc)
+		r := bufio.NewReader(os.Stdin)
+		for {
+			l, err := r.ReadString('\n')
+			if l != "" {
+				inc <- l
+			}
+			if err != nil {
+				return
+			}
 		}
 	}()
 	lastline := ""
@@ -48,9 +121,18 @@ func main() {
 			if _, err := os.Stdout.WriteString(l); err != nil {
 				return
 			}
-		case <-time.After(LineTimeout):
+		case l, ok := <-inc:
+			// Input from an interactive session also resets the timeout.
+			if !ok {
+				inc = nil
+				cmd.Stdin().Close()
+			} else if _, err := cmd.Stdin().Write([]byte(l)); err != nil {
+				inc = nil
+			}
+		case <-time.After(timeout):
 			os.Stdout.WriteString("TIMEOUT\n")
-			os.Exit(2)
+			code = 2
+			return
 		}
 	}
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultPolicyFile = "sitbox.json"
+
+// Policy configures how scripts are isolated from the bot and the host.
+type Policy struct {
+	// Isolate runs scripts in new mount, pid, and network namespaces.
+	Isolate bool
+	// Required refuses to run scripts unisolated when namespaces are
+	// unavailable instead of falling back.
+	Required bool `json:",omitempty"`
+	// Net lists scripts allowed network access; entries may be globs.
+	Net []string `json:",omitempty"`
+	// Callback lists scripts that call back to the bot, which get
+	// SITBOT_URL and network access.
+	Callback []string `json:",omitempty"`
+	// LineTimeoutMs overrides how long scripts matching each glob may go
+	// without printing a line.
+	LineTimeoutMs map[string]int `json:",omitempty"`
+	// ReadOnly remounts the filesystem read-only, save for the state dir.
+	ReadOnly bool `json:",omitempty"`
+	// StateDir holds a writable working directory for each script.
+	StateDir string `json:",omitempty"`
+	// Seccomp denies syscalls for tampering with the kernel or other
+	// processes.
+	Seccomp bool `json:",omitempty"`
+
+	// Cgroup is a delegated cgroup v2 directory for per-script limits,
+	// using the formats of memory.max, cpu.max, and pids.max.
+	Cgroup    string `json:",omitempty"`
+	MemoryMax string `json:",omitempty"`
+	CPUMax    string `json:",omitempty"`
+	PidsMax   string `json:",omitempty"`
+
+	// Env lists environment variables passed to scripts; if empty,
+	// defaultEnv is used.
+	Env []string `json:",omitempty"`
+}
+
+// defaultEnv leaves out SITBOT_URL so scripts can't call back to the bot.
+var defaultEnv = []string{
+	"PATH", "LANG", "HOME", "TERM", "TZ",
+	"SITBOT_ID", "SITBOT_NICK", "SITBOT_FROM", "SITBOT_CHAN", "SITBOT_MSG", "SITBOT_TID",
+	"SITBOT_TOKEN", "SITBOT_SCRIPT",
+}
+
+// defaultPolicy applies without a policy file. Scripts are isolated
+// where the system allows it, and only the bundled scripts that need it
+// reach the network or the bot.
+var defaultPolicy = Policy{
+	Isolate:       true,
+	Seccomp:       true,
+	Net:           []string{"url"},
+	Callback:      []string{"*.super", "botcheck-challenge", "broadcast", "dismiss", "join"},
+	LineTimeoutMs: map[string]int{"chess-*": 30000},
+}
+
+// loadPolicy reads the policy named by SITBOX_POLICY or sitbox.json,
+// using defaultPolicy if there is no such file.
+func loadPolicy() (*Policy, error) {
+	fn := os.Getenv("SITBOX_POLICY")
+	if fn == "" {
+		fn = defaultPolicyFile
+	}
+	b, err := os.ReadFile(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			p := defaultPolicy
+			return &p, nil
+		}
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func matchScript(globs []string, name string) bool {
+	for _, g := range globs {
+		if ok, _ := path.Match(g, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) hasNet(name string) bool {
+	return matchScript(p.Net, name) || matchScript(p.Callback, name)
+}
+
+// lineTimeout is how long a script may go without printing a line.
+func (p *Policy) lineTimeout(name string) time.Duration {
+	for g, ms := range p.LineTimeoutMs {
+		if ok, _ := path.Match(g, name); ok && ms > 0 {
+			return time.Duration(ms) * time.Millisecond
+		}
+	}
+	return LineTimeout
+}
+
+// fallback drops namespaces after they could not be set up, unless
+// isolation is required. Seccomp stays on.
+func (p *Policy) fallback() (*Policy, bool) {
+	if p.Required || !p.Isolate {
+		return nil, false
+	}
+	np := *p
+	np.Isolate = false
+	return &np, true
+}
+
+func (p *Policy) environ(name string) (ret []string) {
+	names := p.Env
+	if len(names) == 0 {
+		names = defaultEnv
+	}
+	if matchScript(p.Callback, name) {
+		names = append(names[:len(names):len(names)], "SITBOT_URL")
+	}
+	for _, kv := range os.Environ() {
+		k, _, _ := strings.Cut(kv, "=")
+		for _, n := range names {
+			if k == n {
+				ret = append(ret, kv)
+				break
+			}
+		}
+	}
+	return ret
+}
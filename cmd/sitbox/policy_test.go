@@ -0,0 +1,72 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SITBOX_POLICY", filepath.Join(dir, "missing.json"))
+	p, err := loadPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Isolate || !p.Seccomp || p.Required {
+		t.Errorf("expected isolating default policy, got %+v", p)
+	}
+	if !p.hasNet("kick.super") || !p.hasNet("url") || p.hasNet("fortune") {
+		t.Errorf("bad default network access")
+	}
+	if p.lineTimeout("chess-move") <= LineTimeout || p.lineTimeout("fortune") != LineTimeout {
+		t.Errorf("bad default line timeouts")
+	}
+
+	fn := filepath.Join(dir, "sitbox.json")
+	t.Setenv("SITBOX_POLICY", fn)
+	os.WriteFile(fn, []byte(`{"Isolate" : true, "Net" : ["url"], "LineTimeoutMs" : {"slow*" : 100}}`), 0644)
+	if p, err = loadPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Isolate || p.Seccomp || p.hasNet("kick.super") || p.lineTimeout("slowpoke") != 100*time.Millisecond {
+		t.Errorf("bad policy %+v", p)
+	}
+	os.WriteFile(fn, []byte(`{"Isolate" : `), 0644)
+	if _, err = loadPolicy(); err == nil {
+		t.Errorf("expected parse error")
+	}
+}
+
+func TestPolicyEnviron(t *testing.T) {
+	t.Setenv("SITBOT_URL", "http://localhost:12345")
+	t.Setenv("SITBOT_FROM", "alice")
+	t.Setenv("SECRET", "x")
+	p := defaultPolicy
+	env := strings.Join(p.environ("fortune"), " ")
+	if !strings.Contains(env, "SITBOT_FROM=alice") || strings.Contains(env, "SITBOT_URL") || strings.Contains(env, "SECRET") {
+		t.Errorf("bad environment %q", env)
+	}
+	if env := strings.Join(p.environ("join.super"), " "); !strings.Contains(env, "SITBOT_URL=") {
+		t.Errorf("callback script missing SITBOT_URL in %q", env)
+	}
+	// Adding SITBOT_URL must not leak into the shared default list.
+	if strings.Contains(strings.Join(defaultEnv, " "), "SITBOT_URL") {
+		t.Errorf("default environment changed")
+	}
+}
+
+func TestPolicyFallback(t *testing.T) {
+	np, ok := (&Policy{Isolate: true, Seccomp: true}).fallback()
+	if !ok || np.Isolate || !np.Seccomp {
+		t.Errorf("got fallback %+v", np)
+	}
+	if _, ok := np.fallback(); ok {
+		t.Errorf("unisolated policy fell back")
+	}
+	if _, ok := (&Policy{Isolate: true, Required: true}).fallback(); ok {
+		t.Errorf("required isolation fell back")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// initArg re-executes sitbox inside the new namespaces to finish setting
+// up the sandbox before exec'ing the script.
+const initArg = "-sitbox-init"
+const initEnv = "SITBOX_INIT"
+
+// setupFd is where the init process reports a failed setup; it closes on
+// exec, so an empty read means the script started in the sandbox.
+const setupFd = 3
+
+type initConfig struct {
+	Mounts   bool
+	ReadOnly bool
+	Dir      string
+	Seccomp  bool
+}
+
+// command builds the sandboxed command for a script.
+func (p *Policy) command(ctx context.Context, name string, args []string) (*boxCmd, error) {
+	path, err := filepath.Abs("scripts/" + name)
+	if err != nil {
+		return nil, err
+	}
+	ic := initConfig{Mounts: p.Isolate, ReadOnly: p.Isolate && p.ReadOnly, Seccomp: p.Seccomp}
+	if p.StateDir != "" {
+		if ic.Dir, err = filepath.Abs(filepath.Join(p.StateDir, name)); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(ic.Dir, 0700); err != nil {
+			return nil, err
+		}
+	}
+	bc := &boxCmd{setup: func() error { return nil }, cleanup: func() {}}
+	if ic.Mounts || ic.Seccomp {
+		b, err := json.Marshal(&ic)
+		if err != nil {
+			return nil, err
+		}
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		bc.Cmd = exec.CommandContext(ctx, "/proc/self/exe", append([]string{initArg, path}, args...)...)
+		bc.Env = append(p.environ(name), initEnv+"="+string(b))
+		bc.ExtraFiles = []*os.File{w}
+		bc.setup = func() error {
+			w.Close()
+			defer r.Close()
+			msg, err := io.ReadAll(r)
+			if err != nil {
+				return err
+			} else if m, ok := strings.CutPrefix(string(msg), errNoNamespaces.Error()); ok {
+				return fmt.Errorf("sandbox setup failed (%w%s)", errNoNamespaces, m)
+			} else if len(msg) > 0 {
+				return fmt.Errorf("sandbox setup failed (%s)", msg)
+			}
+			return nil
+		}
+		bc.cleanup = func() {
+			w.Close()
+			r.Close()
+		}
+	} else {
+		bc.Cmd = exec.CommandContext(ctx, path, args...)
+		bc.Env, bc.Dir = p.environ(name), ic.Dir
+	}
+	attr := &syscall.SysProcAttr{Pdeathsig: syscall.SIGKILL}
+	bc.SysProcAttr = attr
+	if p.Isolate {
+		attr.Cloneflags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		if !p.hasNet(name) {
+			attr.Cloneflags |= syscall.CLONE_NEWNET
+		}
+		if uid, gid := os.Geteuid(), os.Getegid(); uid != 0 {
+			attr.Cloneflags |= syscall.CLONE_NEWUSER
+			attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+			attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+		}
+	}
+	if p.Cgroup == "" {
+		return bc, nil
+	}
+	cg, err := p.newCgroup(name)
+	if err != nil {
+		bc.cleanup()
+		return nil, err
+	}
+	f, err := os.Open(cg)
+	if err != nil {
+		bc.cleanup()
+		os.Remove(cg)
+		return nil, err
+	}
+	attr.UseCgroupFD, attr.CgroupFD = true, int(f.Fd())
+	pipes := bc.cleanup
+	bc.cleanup = func() {
+		pipes()
+		f.Close()
+		os.Remove(cg)
+	}
+	return bc, nil
+}
+
+func (p *Policy) newCgroup(name string) (string, error) {
+	cg := filepath.Join(p.Cgroup, fmt.Sprintf("%s-%d", name, os.Getpid()))
+	if err := os.Mkdir(cg, 0755); err != nil {
+		return "", err
+	}
+	for k, v := range map[string]string{"memory.max": p.MemoryMax, "cpu.max": p.CPUMax, "pids.max": p.PidsMax} {
+		if v == "" {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(cg, k), []byte(v), 0644); err != nil {
+			os.Remove(cg)
+			return "", err
+		}
+	}
+	return cg, nil
+}
+
+// nsUnavailable reports whether a start error means namespaces can't be
+// created by this user, so the script may run without them.
+func nsUnavailable(err error) bool {
+	return errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EINVAL) ||
+		errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EUSERS)
+}
+
+func isInit() bool { return len(os.Args) > 2 && os.Args[1] == initArg && os.Getenv(initEnv) != "" }
+
+// runInit runs inside the sandbox's namespaces and execs the script.
+func runInit() {
+	// Seccomp filters and exec must be on the same thread.
+	runtime.LockOSThread()
+	var ic initConfig
+	if err := json.Unmarshal([]byte(os.Getenv(initEnv)), &ic); err != nil {
+		initFail(err)
+	}
+	os.Unsetenv(initEnv)
+	if err := ic.setup(); err != nil {
+		initFail(err)
+	}
+	syscall.CloseOnExec(setupFd)
+	err := syscall.Exec(os.Args[2], os.Args[2:], os.Environ())
+	fmt.Fprintln(os.Stderr, "sitbox:", err)
+	os.Exit(1)
+}
+
+// initFail tells the parent sitbox the sandbox could not be set up.
+func initFail(err error) {
+	os.NewFile(setupFd, "setup").WriteString(err.Error())
+	os.Exit(1)
+}
+
+func (ic *initConfig) setup() error {
+	if ic.Mounts {
+		if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+			return fmt.Errorf("%w: %v", errNoNamespaces, err)
+		}
+		if ic.Dir != "" {
+			// Bind the state dir so it stays writable after a remount.
+			if err := syscall.Mount(ic.Dir, ic.Dir, "", syscall.MS_BIND, ""); err != nil {
+				return err
+			}
+		}
+		// Best effort; only the new pid namespace's processes are visible.
+		syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	}
+	if ic.ReadOnly {
+		if err := ic.remountReadOnly(); err != nil {
+			return err
+		}
+	}
+	if ic.Dir != "" {
+		if err := os.Chdir(ic.Dir); err != nil {
+			return err
+		}
+	}
+	if ic.Seccomp {
+		return loadSeccomp()
+	}
+	return nil
+}
+
+func (ic *initConfig) remountReadOnly() error {
+	f, err := os.Open("/proc/self/mounts")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var mnts []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if fs := strings.Fields(s.Text()); len(fs) > 1 {
+			mnts = append(mnts, fs[1])
+		}
+	}
+	for _, mnt := range mnts {
+		if mnt == ic.Dir || mnt == "/proc" || strings.HasPrefix(mnt, "/proc/") {
+			continue
+		}
+		flags := uintptr(syscall.MS_REMOUNT | syscall.MS_BIND | syscall.MS_RDONLY)
+		if err := syscall.Mount("", mnt, "", flags, ""); err != nil && mnt == "/" {
+			return err
+		}
+	}
+	return nil
+}
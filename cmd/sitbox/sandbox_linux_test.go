@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// The sandbox runs the test binary as its init process.
+	if isInit() {
+		var ic initConfig
+		json.Unmarshal([]byte(os.Getenv(initEnv)), &ic)
+		switch os.Getenv("SITBOX_TEST_SETUP_FAIL") {
+		case "mounts":
+			if ic.Mounts {
+				initFail(fmt.Errorf("%w: test failure", errNoNamespaces))
+			}
+		case "seccomp":
+			initFail(errors.New("test failure"))
+		}
+		runInit()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func TestStartFallback(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "scripts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "scripts", "hi"), []byte("#!/bin/sh\necho hi $1\ngrep Seccomp: /proc/self/status\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	t.Setenv("SITBOX_TEST_SETUP_FAIL", "mounts")
+	p := &Policy{Isolate: true, Seccomp: true, Env: []string{"PATH", "SITBOX_TEST_SETUP_FAIL"}}
+	cmd, cleanup, err := startCmd(context.Background(), p, "hi", []string{"there"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup()
+	var out []string
+	for l := range cmd.Lines() {
+		out = append(out, l)
+	}
+	if err := cmd.Close(); err != nil {
+		t.Error(err)
+	}
+	// The unisolated fallback keeps seccomp.
+	if len(out) != 2 || out[0] != "hi there\n" || out[1] != "Seccomp:\t2\n" {
+		t.Errorf("got %q", out)
+	}
+
+	p.Required = true
+	if _, _, err := startCmd(context.Background(), p, "hi", nil); err == nil {
+		t.Errorf("expected required isolation to fail")
+	}
+
+	// Other setup failures never run the script without its sandbox.
+	t.Setenv("SITBOX_TEST_SETUP_FAIL", "seccomp")
+	p.Required = false
+	if _, _, err := startCmd(context.Background(), p, "hi", nil); err == nil {
+		t.Errorf("expected seccomp setup failure to fail")
+	}
+}
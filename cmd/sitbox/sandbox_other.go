@@ -0,0 +1,25 @@
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"os/exec"
+	"path/filepath"
+)
+
+// command runs the script unisolated; namespaces are Linux-only.
+func (p *Policy) command(ctx context.Context, name string, args []string) (*boxCmd, error) {
+	cmd := exec.CommandContext(ctx, "scripts/"+name, args...)
+	cmd.Env = p.environ(name)
+	if p.StateDir != "" {
+		cmd.Dir = filepath.Join(p.StateDir, name)
+	}
+	return &boxCmd{Cmd: cmd, setup: func() error { return nil }, cleanup: func() {}}, nil
+}
+
+func nsUnavailable(err error) bool { return false }
+
+func isInit() bool { return false }
+
+func runInit() {}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prSetNoNewPrivs   = 38
+	prSetSeccomp      = 22
+	seccompModeFilter = 2
+
+	seccompRetKill  = 0x80000000
+	seccompRetErrno = 0x00050000
+	seccompRetAllow = 0x7fff0000
+
+	// x32 syscalls on amd64 set this bit.
+	x32SyscallBit = 0x40000000
+
+	// clone3 passes its flags in memory the filter cannot read, so it
+	// fails with ENOSYS and libc falls back to clone.
+	sysClone3 = 435
+
+	// cloneNewFlags are the clone flags creating namespaces.
+	cloneNewFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWCGROUP |
+		syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUSER |
+		syscall.CLONE_NEWPID | syscall.CLONE_NEWNET
+)
+
+var auditArch = map[string]uint32{
+	"386":   0x40000003,
+	"amd64": 0xc000003e,
+	"arm64": 0xc00000b7,
+}
+
+// deniedSyscalls fail with EPERM inside the sandbox.
+var deniedSyscalls = []uint32{
+	syscall.SYS_PTRACE,
+	syscall.SYS_MOUNT,
+	syscall.SYS_UMOUNT2,
+	syscall.SYS_PIVOT_ROOT,
+	syscall.SYS_UNSHARE,
+	syscall.SYS_KEXEC_LOAD,
+	syscall.SYS_INIT_MODULE,
+	syscall.SYS_DELETE_MODULE,
+	syscall.SYS_REBOOT,
+	syscall.SYS_SWAPON,
+	syscall.SYS_SWAPOFF,
+	syscall.SYS_ADD_KEY,
+	syscall.SYS_KEYCTL,
+	syscall.SYS_REQUEST_KEY,
+	syscall.SYS_PERF_EVENT_OPEN,
+}
+
+// archDeniedSyscalls are denied syscalls missing from package syscall,
+// numbered per architecture: setns, bpf, userfaultfd, process_vm_readv,
+// and process_vm_writev.
+var archDeniedSyscalls = map[string][]uint32{
+	"386":   {346, 357, 374, 347, 348},
+	"amd64": {308, 321, 323, 310, 311},
+	"arm64": {268, 280, 282, 270, 271},
+}
+
+// bpfStmt and bpfJump build filter instructions with unsigned constants,
+// which overflow the int arguments of syscall.LsfStmt on 32-bit systems.
+func bpfStmt(code uint16, k uint32) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, K: k}
+}
+
+func bpfJump(code uint16, k uint32, jt, jf uint8) syscall.SockFilter {
+	return syscall.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// loadSeccomp installs a filter on this thread denying deniedSyscalls,
+// archDeniedSyscalls, and clone with namespace flags.
+func loadSeccomp() error {
+	arch, ok := auditArch[runtime.GOARCH]
+	if !ok {
+		return fmt.Errorf("seccomp unsupported on %s", runtime.GOARCH)
+	}
+	denied := append(append([]uint32{}, deniedSyscalls...), archDeniedSyscalls[runtime.GOARCH]...)
+	n := uint8(len(denied))
+	prog := []syscall.SockFilter{
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 4),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, arch, 1, 0),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKill),
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 0),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JGE|syscall.BPF_K, x32SyscallBit, n+5, 0),
+	}
+	for i, nr := range denied {
+		prog = append(prog, bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, nr, n+4-uint8(i), 0))
+	}
+	prog = append(prog,
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, sysClone3, 5, 0),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.SYS_CLONE, 0, 2),
+		// The low word of clone's flags argument.
+		bpfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, 16),
+		bpfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, cloneNewFlags, 1, 0),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetAllow),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM)),
+		bpfStmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.ENOSYS)))
+	fprog := syscall.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return errno
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetSeccomp, seccompModeFilter, uintptr(unsafe.Pointer(&fprog)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -5,12 +5,14 @@ go 1.23.1
 require (
 	github.com/andlabs/ui v0.0.0-20200610043537-70a69d6ae31e
 	github.com/bwmarrin/discordgo v0.28.1
+	github.com/rivo/uniseg v0.4.7
 	github.com/spf13/cobra v1.8.1
 	golang.org/x/image v0.21.0
 	golang.org/x/net v0.30.0
 	golang.org/x/text v0.19.0
 	golang.org/x/time v0.7.0
 	gopkg.in/sorcix/irc.v2 v2.0.0-20200812151606-3f15758ea8c7
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
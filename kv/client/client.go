@@ -0,0 +1,109 @@
+// Package client accesses the bot's key-value store from a script.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/chzchzchz/sitbot/kv"
+)
+
+type Client struct {
+	url   string
+	token string
+	c     *http.Client
+}
+
+// New makes a client for the script's namespace from its SITBOT_*
+// environment.
+func New() *Client {
+	return NewNamespace(os.Getenv("SITBOT_SCRIPT"))
+}
+
+func NewNamespace(ns string) *Client {
+	return &Client{
+		url:   os.Getenv("SITBOT_URL") + "/bot/" + os.Getenv("SITBOT_ID") + "/kv/" + url.PathEscape(ns) + "/",
+		token: os.Getenv("SITBOT_TOKEN"),
+		c:     &http.Client{},
+	}
+}
+
+func (c *Client) do(method, key string, q url.Values, body []byte, v interface{}) error {
+	u := c.url + url.PathEscape(key)
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return kv.ErrNotFound
+	case http.StatusConflict:
+		return kv.ErrConflict
+	default:
+		b, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func ttlValues(ttl time.Duration) url.Values {
+	q := url.Values{}
+	if ttl > 0 {
+		q.Set("ttl", ttl.String())
+	}
+	return q
+}
+
+func (c *Client) Get(key string) (e kv.Entry, err error) {
+	err = c.do(http.MethodGet, key, nil, nil, &e)
+	return e, err
+}
+
+// Put sets a key; a nonzero ttl expires it after that duration.
+func (c *Client) Put(key, val string, ttl time.Duration) (e kv.Entry, err error) {
+	err = c.do(http.MethodPut, key, ttlValues(ttl), []byte(val), &e)
+	return e, err
+}
+
+// CompareAndSwap sets a key only if its version is ver; version 0 only
+// creates new keys.
+func (c *Client) CompareAndSwap(key, val string, ver uint64, ttl time.Duration) (e kv.Entry, err error) {
+	q := ttlValues(ttl)
+	q.Set("version", strconv.FormatUint(ver, 10))
+	err = c.do(http.MethodPut, key, q, []byte(val), &e)
+	return e, err
+}
+
+// Delete removes a key; a nonzero ver only deletes that version.
+func (c *Client) Delete(key string, ver uint64) error {
+	q := url.Values{}
+	if ver != 0 {
+		q.Set("version", strconv.FormatUint(ver, 10))
+	}
+	return c.do(http.MethodDelete, key, q, nil, nil)
+}
+
+func (c *Client) List(prefix string) (ents []kv.Entry, err error) {
+	err = c.do(http.MethodGet, "", url.Values{"prefix": {prefix}}, nil, &ents)
+	return ents, err
+}
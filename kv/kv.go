@@ -0,0 +1,207 @@
+package kv
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("key not found")
+var ErrConflict = errors.New("version conflict")
+
+type Entry struct {
+	Key   string
+	Value string
+	// Version is the store revision of the entry's last change.
+	Version uint64
+	Expires time.Time
+}
+
+func (e *Entry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && now.After(e.Expires)
+}
+
+// Store is a key-value store persisted to a JSON file on every change.
+type Store struct {
+	path string
+	rev  uint64
+	ents map[string]*Entry
+	mu   sync.Mutex
+}
+
+type storeFile struct {
+	Rev     uint64
+	Entries map[string]*Entry
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, ents: make(map[string]*Entry)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sf := storeFile{}
+	if err := json.Unmarshal(b, &sf); err != nil {
+		return nil, err
+	}
+	s.rev = sf.Rev
+	if sf.Entries != nil {
+		s.ents = sf.Entries
+	}
+	return s, nil
+}
+
+func (s *Store) save() error {
+	now := time.Now()
+	for k, e := range s.ents {
+		if e.expired(now) {
+			delete(s.ents, k)
+		}
+	}
+	b, err := json.Marshal(&storeFile{Rev: s.rev, Entries: s.ents})
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *Store) lookup(key string) *Entry {
+	e := s.ents[key]
+	if e != nil && e.expired(time.Now()) {
+		delete(s.ents, key)
+		return nil
+	}
+	return e
+}
+
+func (s *Store) Get(key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e := s.lookup(key); e != nil {
+		return *e, nil
+	}
+	return Entry{}, ErrNotFound
+}
+
+// Put sets a key; a nonzero ttl expires the entry after that duration.
+func (s *Store) Put(key, val string, ttl time.Duration) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(key, val, ttl)
+}
+
+// CompareAndSwap sets a key only if its version is ver; a version of 0
+// only creates the key if it does not exist.
+func (s *Store) CompareAndSwap(key, val string, ver uint64, ttl time.Duration) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.version(key) != ver {
+		return Entry{}, ErrConflict
+	}
+	return s.put(key, val, ttl)
+}
+
+func (s *Store) version(key string) uint64 {
+	if e := s.lookup(key); e != nil {
+		return e.Version
+	}
+	return 0
+}
+
+func (s *Store) put(key, val string, ttl time.Duration) (Entry, error) {
+	old, rev := s.ents[key], s.rev
+	s.rev++
+	e := &Entry{Key: key, Value: val, Version: s.rev}
+	if ttl > 0 {
+		e.Expires = time.Now().Add(ttl)
+	}
+	s.ents[key] = e
+	if err := s.save(); err != nil {
+		s.restore(key, old, rev)
+		return Entry{}, err
+	}
+	return *e, nil
+}
+
+// restore undoes a change to key that could not be saved.
+func (s *Store) restore(key string, old *Entry, rev uint64) {
+	if s.rev = rev; old != nil {
+		s.ents[key] = old
+	} else {
+		delete(s.ents, key)
+	}
+}
+
+// Delete removes a key; a nonzero ver only deletes that version.
+func (s *Store) Delete(key string, ver uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cur := s.version(key)
+	if cur == 0 {
+		return ErrNotFound
+	} else if ver != 0 && cur != ver {
+		return ErrConflict
+	}
+	old := s.ents[key]
+	delete(s.ents, key)
+	if err := s.save(); err != nil {
+		s.restore(key, old, s.rev)
+		return err
+	}
+	return nil
+}
+
+// List returns the entries with keys beginning with prefix, in key order.
+func (s *Store) List(prefix string) (ret []Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k := range s.ents {
+		if strings.HasPrefix(k, prefix) {
+			if e := s.lookup(k); e != nil {
+				ret = append(ret, *e)
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key < ret[j].Key })
+	return ret
+}
+
+// Dir opens stores by name as files in a directory.
+type Dir struct {
+	path   string
+	stores map[string]*Store
+	mu     sync.Mutex
+}
+
+func NewDir(path string) *Dir { return &Dir{path: path, stores: make(map[string]*Store)} }
+
+func (d *Dir) Open(name string) (*Store, error) {
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		return nil, errors.New("bad store name")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s := d.stores[name]; s != nil {
+		return s, nil
+	}
+	if err := os.MkdirAll(d.path, 0700); err != nil {
+		return nil, err
+	}
+	s, err := Open(filepath.Join(d.path, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+	d.stores[name] = s
+	return s, nil
+}
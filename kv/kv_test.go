@@ -0,0 +1,87 @@
+package kv
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCompareAndSwap(t *testing.T) {
+	s, err := Open(filepath.Join(t.TempDir(), "kv.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := s.CompareAndSwap("a", "1", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CompareAndSwap("a", "2", 0, 0); err != ErrConflict {
+		t.Fatalf("expected conflict, got %v", err)
+	}
+	if _, err := s.CompareAndSwap("a", "2", e.Version, 0); err != nil {
+		t.Fatal(err)
+	}
+	if e, _ := s.Get("a"); e.Value != "2" {
+		t.Fatalf("expected 2, got %q", e.Value)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	s, _ := Open(filepath.Join(t.TempDir(), "kv.json"))
+	s.Put("a", "1", time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, err := s.Get("a"); err != ErrNotFound {
+		t.Fatalf("expected expired key, got %v", err)
+	}
+}
+
+func TestListReopen(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "kv.json")
+	s, _ := Open(fn)
+	for _, k := range []string{"ns/b", "ns/a", "other/c"} {
+		if _, err := s.Put(k, k, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := Open(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ents := s.List("ns/")
+	if len(ents) != 2 || ents[0].Key != "ns/a" || ents[1].Key != "ns/b" {
+		t.Fatalf("bad list %+v", ents)
+	}
+}
+
+func TestSaveFailure(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "kv.json")
+	s, _ := Open(fn)
+	e, err := s.Put("a", "1", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory in place of the temp file fails every save.
+	if err := os.Mkdir(fn+".tmp", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Put("a", "2", 0); err == nil {
+		t.Fatal("expected put to fail")
+	}
+	if _, err := s.Put("b", "1", 0); err == nil {
+		t.Fatal("expected put to fail")
+	}
+	if err := s.Delete("a", 0); err == nil {
+		t.Fatal("expected delete to fail")
+	}
+	if got, _ := s.Get("a"); got != e {
+		t.Fatalf("expected %+v, got %+v", e, got)
+	}
+	if _, err := s.Get("b"); err != ErrNotFound {
+		t.Fatalf("expected unsaved key to be gone, got %v", err)
+	}
+	os.Remove(fn + ".tmp")
+	if e, err := s.Put("b", "1", 0); err != nil || e.Version != 2 {
+		t.Fatalf("expected version 2, got %+v (%v)", e, err)
+	}
+}
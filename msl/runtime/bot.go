@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"sort"
@@ -20,7 +21,11 @@ func MustBotState() *bot.State {
 	if botState != nil {
 		return botState
 	}
-	r, err := client.Get(botURL())
+	req, err := newRequest(http.MethodGet, nil)
+	if err != nil {
+		panic(err)
+	}
+	r, err := client.Do(req)
 	if err != nil {
 		panic(err)
 	}
@@ -65,13 +70,22 @@ func botURL() string {
 	return os.Getenv("SITBOT_URL") + "/bot/" + os.Getenv("SITBOT_ID")
 }
 
+// newRequest makes a request to the bot authenticated by the task's token.
+func newRequest(method string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, botURL(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv("SITBOT_TOKEN"))
+	return req, nil
+}
+
 func postCommand(msg *irc.Message) error {
 	jsonData, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	url := botURL()
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(jsonData))
+	req, err := newRequest(http.MethodPost, bytes.NewBuffer(jsonData))
 	if err != nil {
 		return err
 	}